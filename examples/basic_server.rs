@@ -12,17 +12,20 @@
 
 use std::collections::HashSet;
 use std::sync::Arc;
+use std::time::Instant;
 
 use async_trait::async_trait;
-use axum::body::Body;
-use axum::extract::State;
+use axum::body::{Body, Bytes, HttpBody};
+use axum::extract::{Request, State};
 use axum::http::{HeaderMap, StatusCode};
+use axum::middleware::{self, Next};
 use axum::response::{IntoResponse, Response};
 use axum::routing::{get, post};
 use axum::{Json, Router};
+use mcpserver::types::{ERR_CODE_INVALID_REQ, ERR_CODE_SESSION_NOT_FOUND};
 use mcpserver::{
-    text_result, FnToolHandler, JsonRpcRequest, McpError, McpResponse, ResourceContent,
-    ResourceHandler, Server, ToolHandler, ToolResult,
+    new_error_response, parse_request, text_result, FnToolHandler, McpError, McpResponse,
+    ResourceContent, ResourceHandler, Server, ToolHandler, ToolResult,
 };
 use serde_json::{json, Value};
 use tokio::sync::RwLock;
@@ -35,13 +38,29 @@ struct AppState {
     sessions: RwLock<HashSet<String>>,
 }
 
+// ── Transport errors share the JSON-RPC error envelope ──
+
+fn rpc_error(status: StatusCode, code: i32, message: &str) -> Response {
+    (status, Json(new_error_response(None, code, message))).into_response()
+}
+
+fn session_not_found() -> Response {
+    rpc_error(StatusCode::NOT_FOUND, ERR_CODE_SESSION_NOT_FOUND, "Session not found")
+}
+
 // ── Axum handler: JSON-RPC → Server::handle() → HTTP response ──
 
 async fn handle_mcp(
     State(state): State<Arc<AppState>>,
     headers: HeaderMap,
-    Json(req): Json<JsonRpcRequest>,
+    body: Bytes,
 ) -> Response {
+    // Invalid JSON gets 400 with a -32700 error rather than a plain-text body.
+    let req = match parse_request(&body) {
+        Ok(req) => req,
+        Err(err) => return (StatusCode::BAD_REQUEST, Json(err)).into_response(),
+    };
+
     // Session management: create on initialize, pass through otherwise.
     let session_id = if req.method == "initialize" {
         let id = Uuid::new_v4().to_string();
@@ -54,10 +73,29 @@ async fn handle_mcp(
             .map(|s| s.to_string())
     };
 
+    // A terminated or unknown session gets 404, telling the client to
+    // initialize again.
+    if let Some(sid) = &session_id {
+        if !state.sessions.read().await.contains(sid) {
+            return session_not_found();
+        }
+    }
+
     // Build request context from the HTTP layer.
     // In a real app, this would contain decoded JWT claims, tenant info, etc.
-    // For this demo we just pass an empty object.
-    let context = json!({});
+    // `sessionId` is picked up by the server's per-request tracing span.
+    // `locale` is read by tools that set `localize`, and `resultShape`
+    // selects a legacy result format.
+    let mut context = match &session_id {
+        Some(sid) => json!({"sessionId": sid}),
+        None => json!({}),
+    };
+    if let Some(lang) = headers.get("accept-language").and_then(|h| h.to_str().ok()) {
+        context["locale"] = json!(lang);
+    }
+    if let Some(shape) = headers.get("x-result-shape").and_then(|h| h.to_str().ok()) {
+        context["resultShape"] = json!(shape);
+    }
 
     // The library handles all MCP protocol logic.
     // McpResponse holds Arc references to pre-serialized JSON for cached
@@ -81,6 +119,52 @@ async fn handle_mcp(
     response
 }
 
+// ── Axum handler: DELETE /mcp terminates the session ──
+
+async fn delete_session(State(state): State<Arc<AppState>>, headers: HeaderMap) -> Response {
+    let Some(sid) = headers.get("mcp-session-id").and_then(|h| h.to_str().ok()) else {
+        let message = "Missing mcp-session-id header";
+        return rpc_error(StatusCode::BAD_REQUEST, ERR_CODE_INVALID_REQ, message);
+    };
+    if !state.sessions.write().await.remove(sid) {
+        return session_not_found();
+    }
+    // Drop the server's per-session state too (log level, protocol
+    // version, pending server-to-client requests).
+    state.server.end_session(sid);
+    StatusCode::NO_CONTENT.into_response()
+}
+
+async fn method_not_allowed() -> Response {
+    rpc_error(StatusCode::METHOD_NOT_ALLOWED, ERR_CODE_INVALID_REQ, "Method not allowed")
+}
+
+// ── Access log: one debug line per HTTP request, whatever its status ──
+
+fn session_header(headers: &HeaderMap) -> Option<String> {
+    headers.get("mcp-session-id").and_then(|h| h.to_str().ok()).map(String::from)
+}
+
+async fn access_log(req: Request, next: Next) -> Response {
+    let started = Instant::now();
+    let (method, path) = (req.method().clone(), req.uri().path().to_string());
+    let session = session_header(req.headers());
+    let response = next.run(req).await;
+    // A session created by this request is only known from the response.
+    let session = session.or_else(|| session_header(response.headers()));
+    tracing::debug!(
+        target: "access",
+        %method,
+        path,
+        status = response.status().as_u16(),
+        bytes = response.body().size_hint().exact(),
+        elapsed_ms = started.elapsed().as_millis() as u64,
+        session,
+        "request"
+    );
+    response
+}
+
 // ── Tool & resource handlers ──
 
 struct EchoHandler;
@@ -106,6 +190,7 @@ impl ResourceHandler for ConfigHandler {
             mime_type: Some("application/json".into()),
             text: Some(r#"{"debug": false, "version": "1.0"}"#.into()),
             blob: None,
+            meta: None,
         })
     }
 }
@@ -178,13 +263,21 @@ async fn main() {
     });
 
     let app = Router::new()
-        .route("/healthz", get(|| async { Json(json!({"status": "ok"})) }))
-        .route("/mcp", post(handle_mcp))
-        .with_state(state);
+        .route(
+            "/healthz",
+            get(|State(state): State<Arc<AppState>>| async move { Json(state.server.health()) }),
+        )
+        .route(
+            "/mcp",
+            post(handle_mcp).delete(delete_session).fallback(method_not_allowed),
+        )
+        .with_state(state)
+        .layer(middleware::from_fn(access_log));
 
     let listener = tokio::net::TcpListener::bind("0.0.0.0:3000").await.unwrap();
     println!("MCP server listening on http://localhost:3000");
     println!("  POST /mcp     — MCP JSON-RPC endpoint");
+    println!("  DELETE /mcp   — end the session in the mcp-session-id header");
     println!("  GET  /healthz — health check");
     axum::serve(listener, app).await.unwrap();
 }
@@ -30,7 +30,7 @@
 use std::sync::Arc;
 
 use async_trait::async_trait;
-use axum::body::Body;
+use axum::body::{Body, Bytes};
 use axum::extract::{Request, State};
 use axum::http::StatusCode;
 use axum::middleware::{self, Next};
@@ -39,7 +39,7 @@ use axum::routing::{get, post};
 use axum::{Extension, Json, Router};
 use jsonwebtoken::{decode, decode_header, Algorithm, DecodingKey, Validation};
 use mcpserver::{
-    text_result, FnToolHandler, JsonRpcRequest, McpError, McpResponse, Server, ToolHandler,
+    parse_request, text_result, FnToolHandler, McpError, McpResponse, Server, ToolHandler,
     ToolResult,
 };
 use serde::{Deserialize, Serialize};
@@ -169,8 +169,14 @@ struct AppState {
 async fn handle_mcp(
     State(state): State<Arc<AppState>>,
     Extension(claims): Extension<Value>,
-    Json(req): Json<JsonRpcRequest>,
+    body: Bytes,
 ) -> Response {
+    // Invalid JSON gets 400 with a JSON-RPC -32700 error body.
+    let req = match parse_request(&body) {
+        Ok(req) => req,
+        Err(err) => return (StatusCode::BAD_REQUEST, Json(err)).into_response(),
+    };
+
     // Pass the decoded Cognito claims as context to tool handlers.
     let resp: McpResponse = state.server.handle(req, claims).await;
 
@@ -0,0 +1,839 @@
+//! Full MCP server example with Axum HTTP transport.
+//!
+//! `basic_server.rs` plus the transport features a deployment adds around
+//! the library; see "Running the full server example" in the README.
+//!
+//! This shows how to wire `Server::handle()` into an Axum app — the library
+//! is a pure protocol handler, so *you* own the HTTP layer (routes, middleware,
+//! status codes, session management, and identity/context).
+//!
+//! Run with: `cargo run --example full_server`
+//! Then test with:
+//!   curl -X POST http://localhost:3000/mcp \
+//!     -H "Content-Type: application/json" \
+//!     -d '{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}'
+//! and open the session's notification stream with:
+//!   curl -N http://localhost:3000/mcp -H "Accept: text/event-stream" \
+//!     -H "mcp-session-id: <id from the initialize response>"
+//!
+//! Set TLS_CERT and TLS_KEY (PEM files) to serve HTTPS, and TLS_CLIENT_CA
+//! as well to require client certificates signed by that CA (mutual TLS).
+
+use std::collections::{HashMap, VecDeque};
+use std::convert::Infallible;
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use async_trait::async_trait;
+use axum::body::{Body, Bytes, HttpBody};
+use axum::extract::{Query, Request, State};
+use axum::http::{HeaderMap, StatusCode};
+use axum::middleware::{self, Next};
+use axum::response::sse::{Event, KeepAlive, Sse};
+use axum::response::{IntoResponse, Response};
+use axum::routing::{get, post};
+use axum::{Json, Router};
+use axum_server::tls_rustls::RustlsConfig;
+use futures_util::{stream, StreamExt};
+use mcpserver::types::{ERR_CODE_INVALID_REQ, ERR_CODE_SESSION_NOT_FOUND};
+use mcpserver::{
+    new_error_response, parse_request, text_result, FnToolHandler, JsonRpcNotification,
+    JsonRpcResponse, McpError, McpResponse, ResourceContent, ResourceHandler, Server,
+    ServerBuilder, ServerRequest, ToolHandler, ToolResult,
+};
+use rustls::pki_types::pem::PemObject;
+use rustls::pki_types::{CertificateDer, PrivateKeyDer};
+use rustls::server::WebPkiClientVerifier;
+use rustls::RootCertStore;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use tokio::sync::{mpsc, RwLock};
+use tower_http::compression::CompressionLayer;
+use uuid::Uuid;
+
+// ── Shared state for the HTTP layer ──
+
+struct AppState {
+    server: Server,
+    sessions: RwLock<HashMap<String, Session>>,
+    /// Sessions older than this are ended, however active, so long-running
+    /// agents pick up rotated credentials and changed capabilities.
+    max_session_age: Option<Duration>,
+    streams: Arc<Streams>,
+    /// Mints session IDs.  Swap it for deterministic IDs in tests, or to
+    /// embed a region or replica prefix.
+    new_session_id: Box<dyn Fn() -> String + Send + Sync>,
+}
+
+/// A live session's timestamps.
+struct Session {
+    created: Instant,
+    /// When the client last sent anything, a ping response included.
+    last_seen: Instant,
+}
+
+impl Session {
+    fn new() -> Self {
+        let now = Instant::now();
+        Session { created: now, last_seen: now }
+    }
+}
+
+impl AppState {
+    fn new(server: Server, streams: Arc<Streams>, settings: &Settings) -> Arc<Self> {
+        let id_prefix = settings.id_prefix.clone();
+        Arc::new(AppState {
+            server,
+            sessions: RwLock::new(HashMap::new()),
+            max_session_age: settings.max_session_age,
+            streams,
+            new_session_id: Box::new(move || format!("{}{}", id_prefix, Uuid::new_v4())),
+        })
+    }
+
+    /// Whether `sid` names a live session, noting the client's activity.
+    /// A session past its maximum age is ended here, and the caller's 404
+    /// sends the client back to initialize.
+    async fn session_live(&self, sid: &str) -> bool {
+        let created = {
+            let mut sessions = self.sessions.write().await;
+            let Some(session) = sessions.get_mut(sid) else {
+                return false;
+            };
+            session.last_seen = Instant::now();
+            session.created
+        };
+        if self.max_session_age.is_some_and(|max| created.elapsed() > max) {
+            tracing::info!(session = %sid, "session reached its maximum age");
+            self.end_session(sid, Some("expired")).await;
+            return false;
+        }
+        true
+    }
+
+    /// End every session past its maximum age, while its client can still
+    /// be told on the stream.
+    async fn expire_sessions(&self) {
+        let Some(max) = self.max_session_age else {
+            return;
+        };
+        let aged: Vec<String> = {
+            let sessions = self.sessions.read().await;
+            sessions
+                .iter()
+                .filter(|(_, session)| session.created.elapsed() > max)
+                .map(|(sid, _)| sid.clone())
+                .collect()
+        };
+        for sid in aged {
+            tracing::info!(session = %sid, "session reached its maximum age");
+            self.end_session(&sid, Some("expired")).await;
+        }
+    }
+
+    /// Sessions with an open event stream whose client has been quiet for
+    /// at least `idle`.
+    async fn idle_streams(&self, idle: Duration) -> Vec<String> {
+        let streams = self.streams.open_sessions();
+        let sessions = self.sessions.read().await;
+        streams
+            .into_iter()
+            .filter(|sid| sessions.get(sid).is_some_and(|s| s.last_seen.elapsed() >= idle))
+            .collect()
+    }
+
+    /// Forget the session: close its event stream and drop the server's
+    /// per-session state (log level, protocol version, pending
+    /// server-to-client requests).  Returns false for an unknown ID.
+    ///
+    /// With a `reason`, the server is the one ending the session, and the
+    /// stream's last event is `notifications/session/expiring` so the
+    /// client can initialize again before its next call fails.
+    async fn end_session(&self, sid: &str, reason: Option<&str>) -> bool {
+        if self.sessions.write().await.remove(sid).is_none() {
+            return false;
+        }
+        if let Some(reason) = reason {
+            let params = json!({ "reason": reason });
+            let note = JsonRpcNotification::new("notifications/session/expiring", Some(params));
+            self.streams.send(Some(sid), &note);
+        }
+        // The connection ends once the events already queued have gone out.
+        self.streams.close(sid);
+        self.server.end_session(sid);
+        true
+    }
+}
+
+/// Event streams by session.  The server's notification and request
+/// sinks are synchronous, hence the std mutex and unbounded channels.
+#[derive(Default)]
+struct Streams(std::sync::Mutex<HashMap<String, Stream>>);
+
+/// A session's event stream.  Recent events are kept with their IDs, so a
+/// client that reconnects with `Last-Event-ID` gets the ones it missed.
+#[derive(Default)]
+struct Stream {
+    /// The open `GET /mcp` connection, if any.
+    tx: Option<mpsc::UnboundedSender<(u64, String)>>,
+    last_id: u64,
+    recent: VecDeque<(u64, String)>,
+}
+
+/// Events kept per session for replay.
+const REPLAY_EVENTS: usize = 100;
+
+impl Streams {
+    /// Queue a JSON-RPC message on `session`'s stream, or on every stream
+    /// when `session` is `None`.
+    fn send(&self, session: Option<&str>, message: &impl Serialize) {
+        let Ok(data) = serde_json::to_string(message) else {
+            return;
+        };
+        let mut streams = self.0.lock().unwrap();
+        for (sid, stream) in streams.iter_mut() {
+            if session.is_some_and(|s| s != sid.as_str()) {
+                continue;
+            }
+            stream.last_id += 1;
+            let event = (stream.last_id, data.clone());
+            if stream.recent.len() == REPLAY_EVENTS {
+                stream.recent.pop_front();
+            }
+            stream.recent.push_back(event.clone());
+            // A connection whose client went away is dropped; its events
+            // wait in `recent` for the reconnect.
+            if stream.tx.as_ref().is_some_and(|tx| tx.send(event).is_err()) {
+                stream.tx = None;
+            }
+        }
+    }
+
+    /// Connect to `session`'s stream, replacing any earlier connection.
+    /// Kept events after `last_event_id` are sent first.
+    fn open(
+        &self,
+        session: &str,
+        last_event_id: Option<u64>,
+    ) -> mpsc::UnboundedReceiver<(u64, String)> {
+        let (tx, rx) = mpsc::unbounded_channel();
+        let mut streams = self.0.lock().unwrap();
+        let stream = streams.entry(session.to_string()).or_default();
+        if let Some(last) = last_event_id {
+            for event in stream.recent.iter().filter(|(id, _)| *id > last) {
+                let _ = tx.send(event.clone());
+            }
+        }
+        stream.tx = Some(tx);
+        rx
+    }
+
+    /// Sessions with an open connection.
+    fn open_sessions(&self) -> Vec<String> {
+        let streams = self.0.lock().unwrap();
+        streams.iter().filter(|(_, s)| s.tx.is_some()).map(|(sid, _)| sid.clone()).collect()
+    }
+
+    /// Close the session's connection and forget its events.
+    fn close(&self, session: &str) {
+        self.0.lock().unwrap().remove(session);
+    }
+}
+
+// ── Transport errors share the JSON-RPC error envelope ──
+
+fn rpc_error(status: StatusCode, code: i32, message: &str) -> Response {
+    (status, Json(new_error_response(None, code, message))).into_response()
+}
+
+fn session_not_found() -> Response {
+    rpc_error(StatusCode::NOT_FOUND, ERR_CODE_SESSION_NOT_FOUND, "Session not found")
+}
+
+// ── Axum handler: JSON-RPC → Server::handle() → HTTP response ──
+
+async fn handle_mcp(
+    State(state): State<Arc<AppState>>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response {
+    // Invalid JSON gets 400 with a -32700 error rather than a plain-text body.
+    let req = match parse_request(&body) {
+        Ok(req) => req,
+        Err(err) => match client_response(&body) {
+            Some(resp) => return deliver_client_response(&state, &headers, resp).await,
+            None => return (StatusCode::BAD_REQUEST, Json(err)).into_response(),
+        },
+    };
+
+    // Session management: create on initialize, pass through otherwise.
+    let session_id = if req.method == "initialize" {
+        let id = (state.new_session_id)();
+        state.sessions.write().await.insert(id.clone(), Session::new());
+        Some(id)
+    } else {
+        headers
+            .get("mcp-session-id")
+            .and_then(|h| h.to_str().ok())
+            .map(|s| s.to_string())
+    };
+
+    // A terminated, unknown or expired session gets 404, telling the
+    // client to initialize again.
+    if let Some(sid) = &session_id {
+        if !state.session_live(sid).await {
+            return session_not_found();
+        }
+    }
+
+    let context = request_context(session_id.as_deref(), &headers);
+
+    // The library handles all MCP protocol logic.
+    // McpResponse holds Arc references to pre-serialized JSON for cached
+    // endpoints — zero data copying.
+    let resp: McpResponse = state.server.handle(req, context).await;
+
+    // Notifications get 202 with no body.
+    if resp.is_notification() {
+        return (StatusCode::ACCEPTED, Body::empty()).into_response();
+    }
+
+    // McpResponse implements Serialize — cached results are embedded verbatim.
+    let mut response = Json(&resp).into_response();
+
+    if let Some(sid) = session_id {
+        response
+            .headers_mut()
+            .insert("mcp-session-id", sid.parse().unwrap());
+    }
+
+    response
+}
+
+/// Build request context from the HTTP layer.
+/// In a real app, this would contain decoded JWT claims, tenant info, etc.
+/// `sessionId` is picked up by the server's per-request tracing span.
+/// `locale` is read by tools that set `localize`, and `resultShape`
+/// selects a legacy result format.
+fn request_context(session_id: Option<&str>, headers: &HeaderMap) -> Value {
+    let mut context = match session_id {
+        Some(sid) => json!({"sessionId": sid}),
+        None => json!({}),
+    };
+    if let Some(lang) = headers.get("accept-language").and_then(|h| h.to_str().ok()) {
+        context["locale"] = json!(lang);
+    }
+    if let Some(shape) = headers.get("x-result-shape").and_then(|h| h.to_str().ok()) {
+        context["resultShape"] = json!(shape);
+    }
+    context
+}
+
+// ── POSTed responses answer server-to-client requests (sampling, roots) ──
+
+/// A body with `result` or `error` and no `method`.
+fn client_response(body: &[u8]) -> Option<JsonRpcResponse> {
+    let value: Value = serde_json::from_slice(body).ok()?;
+    let is_response = value.get("method").is_none()
+        && (value.get("result").is_some() || value.get("error").is_some());
+    is_response.then(|| serde_json::from_value(value).ok()).flatten()
+}
+
+async fn deliver_client_response(
+    state: &AppState,
+    headers: &HeaderMap,
+    resp: JsonRpcResponse,
+) -> Response {
+    let Some(sid) = session_header(headers) else {
+        let message = "Missing mcp-session-id header";
+        return rpc_error(StatusCode::BAD_REQUEST, ERR_CODE_INVALID_REQ, message);
+    };
+    if !state.session_live(&sid).await {
+        return session_not_found();
+    }
+    if !state.server.handle_client_response(&sid, resp) {
+        tracing::debug!(session = %sid, "response to no pending request");
+    }
+    StatusCode::ACCEPTED.into_response()
+}
+
+// ── Axum handler: GET /mcp opens the session's server-to-client stream ──
+
+async fn open_stream(State(state): State<Arc<AppState>>, headers: HeaderMap) -> Response {
+    let accept = headers.get("accept").and_then(|h| h.to_str().ok()).unwrap_or_default();
+    if !accept.contains("text/event-stream") {
+        return method_not_allowed().await;
+    }
+    let Some(sid) = session_header(&headers) else {
+        let message = "Missing mcp-session-id header";
+        return rpc_error(StatusCode::BAD_REQUEST, ERR_CODE_INVALID_REQ, message);
+    };
+    if !state.session_live(&sid).await {
+        return session_not_found();
+    }
+
+    // A new stream for the session replaces the old one.  A reconnecting
+    // client names the last event it saw, and gets the ones after it.
+    let last_event_id = headers
+        .get("last-event-id")
+        .and_then(|h| h.to_str().ok())
+        .and_then(|v| v.parse().ok());
+    let rx = state.streams.open(&sid, last_event_id);
+    let events = stream::unfold(rx, |mut rx| async move {
+        let (id, data) = rx.recv().await?;
+        let event = Event::default().id(id.to_string()).event("message").data(data);
+        Some((Ok::<_, Infallible>(event), rx))
+    });
+    Sse::new(events).keep_alive(KeepAlive::default()).into_response()
+}
+
+// ── Axum handler: DELETE /mcp terminates the session ──
+
+async fn delete_session(State(state): State<Arc<AppState>>, headers: HeaderMap) -> Response {
+    let Some(sid) = headers.get("mcp-session-id").and_then(|h| h.to_str().ok()) else {
+        let message = "Missing mcp-session-id header";
+        return rpc_error(StatusCode::BAD_REQUEST, ERR_CODE_INVALID_REQ, message);
+    };
+    if !state.end_session(sid, None).await {
+        return session_not_found();
+    }
+    StatusCode::NO_CONTENT.into_response()
+}
+
+// ── Legacy HTTP+SSE transport (protocol revision 2024-11-05) ──
+//
+// `GET /sse` starts a session and holds its stream open.  The first event,
+// `endpoint`, names the URL to POST messages to; responses come back on
+// the stream rather than in the POST's body.
+
+/// Ends a legacy session when its stream's connection closes, since the
+/// client has no other way to reach it.
+struct LegacySession {
+    state: Arc<AppState>,
+    sid: String,
+}
+
+impl Drop for LegacySession {
+    fn drop(&mut self) {
+        let (state, sid) = (Arc::clone(&self.state), std::mem::take(&mut self.sid));
+        tokio::spawn(async move { state.end_session(&sid, None).await });
+    }
+}
+
+async fn open_legacy_stream(state: Arc<AppState>, messages_path: &str) -> Response {
+    let sid = (state.new_session_id)();
+    state.sessions.write().await.insert(sid.clone(), Session::new());
+    let rx = state.streams.open(&sid, None);
+
+    let url = format!("{}?sessionId={}", messages_path, sid);
+    let endpoint = Event::default().event("endpoint").data(url);
+    let guard = LegacySession { state, sid };
+    let messages = stream::unfold((rx, guard), |(mut rx, guard)| async move {
+        let (_, data) = rx.recv().await?;
+        Some((Ok(Event::default().event("message").data(data)), (rx, guard)))
+    });
+    let events = stream::once(async { Ok::<_, Infallible>(endpoint) }).chain(messages);
+    Sse::new(events).keep_alive(KeepAlive::default()).into_response()
+}
+
+#[derive(Deserialize)]
+struct LegacyQuery {
+    #[serde(rename = "sessionId")]
+    session_id: String,
+}
+
+async fn post_legacy_message(
+    State(state): State<Arc<AppState>>,
+    Query(query): Query<LegacyQuery>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> Response {
+    let sid = query.session_id;
+    if !state.session_live(&sid).await {
+        return session_not_found();
+    }
+    let req = match parse_request(&body) {
+        Ok(req) => req,
+        Err(err) => match client_response(&body) {
+            Some(resp) => {
+                state.server.handle_client_response(&sid, resp);
+                return StatusCode::ACCEPTED.into_response();
+            }
+            None => return (StatusCode::BAD_REQUEST, Json(err)).into_response(),
+        },
+    };
+    let resp = state.server.handle(req, request_context(Some(&sid), &headers)).await;
+    if !resp.is_notification() {
+        state.streams.send(Some(&sid), &resp);
+    }
+    StatusCode::ACCEPTED.into_response()
+}
+
+async fn method_not_allowed() -> Response {
+    rpc_error(StatusCode::METHOD_NOT_ALLOWED, ERR_CODE_INVALID_REQ, "Method not allowed")
+}
+
+// ── Access log: one debug line per HTTP request, whatever its status ──
+
+fn session_header(headers: &HeaderMap) -> Option<String> {
+    headers.get("mcp-session-id").and_then(|h| h.to_str().ok()).map(String::from)
+}
+
+async fn access_log(req: Request, next: Next) -> Response {
+    let started = Instant::now();
+    let (method, path) = (req.method().clone(), req.uri().path().to_string());
+    let session = session_header(req.headers());
+    let response = next.run(req).await;
+    // A session created by this request is only known from the response.
+    let session = session.or_else(|| session_header(response.headers()));
+    tracing::debug!(
+        target: "access",
+        %method,
+        path,
+        status = response.status().as_u16(),
+        bytes = response.body().size_hint().exact(),
+        elapsed_ms = started.elapsed().as_millis() as u64,
+        session,
+        "request"
+    );
+    response
+}
+
+// ── TLS: server certificate, plus client certificates when a CA is set ──
+
+fn tls_config(
+    cert: &str,
+    key: &str,
+    client_ca: Option<&str>,
+) -> Result<rustls::ServerConfig, Box<dyn std::error::Error>> {
+    let certs = CertificateDer::pem_file_iter(cert)?.collect::<Result<Vec<_>, _>>()?;
+    let key = PrivateKeyDer::from_pem_file(key)?;
+    let builder = rustls::ServerConfig::builder();
+    let mut config = match client_ca {
+        Some(ca) => {
+            let mut roots = RootCertStore::empty();
+            for ca_cert in CertificateDer::pem_file_iter(ca)? {
+                roots.add(ca_cert?)?;
+            }
+            // Handshakes without a certificate signed by the CA fail.
+            let verifier = WebPkiClientVerifier::builder(Arc::new(roots)).build()?;
+            builder.with_client_cert_verifier(verifier).with_single_cert(certs, key)?
+        }
+        None => builder.with_no_client_auth().with_single_cert(certs, key)?,
+    };
+    config.alpn_protocols = vec![b"h2".to_vec(), b"http/1.1".to_vec()];
+    Ok(config)
+}
+
+// ── Tool & resource handlers ──
+
+struct EchoHandler;
+
+#[async_trait]
+impl ToolHandler for EchoHandler {
+    async fn call(&self, args: Value, _context: Value) -> Result<ToolResult, McpError> {
+        let message = args
+            .get("message")
+            .and_then(|v| v.as_str())
+            .unwrap_or("(empty)");
+        Ok(text_result(format!("echo: {}", message)))
+    }
+}
+
+struct ConfigHandler;
+
+#[async_trait]
+impl ResourceHandler for ConfigHandler {
+    async fn call(&self, uri: &str, _context: Value) -> Result<ResourceContent, McpError> {
+        Ok(ResourceContent {
+            uri: uri.to_string(),
+            mime_type: Some("application/json".into()),
+            text: Some(r#"{"debug": false, "version": "1.0"}"#.into()),
+            blob: None,
+            meta: None,
+        })
+    }
+}
+
+// ── Mounting servers: one router can serve several ──
+
+/// Per-process settings, shared by every mounted server.
+struct Settings {
+    /// Leads every session ID.
+    id_prefix: String,
+    max_session_age: Option<Duration>,
+    keepalive: Option<Duration>,
+}
+
+impl Settings {
+    fn from_env() -> Self {
+        let secs = |var: &str| {
+            let value = std::env::var(var).ok()?;
+            value.parse().ok().map(Duration::from_secs)
+        };
+        // With several replicas, REPLICA_ID leads each session ID so the
+        // proxy can send a session back to the replica holding its stream.
+        let mut id_prefix = std::env::var("SESSION_ID_PREFIX").unwrap_or_default();
+        if let Ok(replica) = std::env::var("REPLICA_ID") {
+            id_prefix = format!("{}.{}", replica, id_prefix);
+        }
+        Settings {
+            id_prefix,
+            // SESSION_MAX_AGE_SECS bounds a session's lifetime; unset,
+            // sessions live until DELETE.
+            max_session_age: secs("SESSION_MAX_AGE_SECS"),
+            // KEEPALIVE_SECS turns on pings to quiet streaming sessions.
+            keepalive: secs("KEEPALIVE_SECS"),
+        }
+    }
+}
+
+/// Route paths within a mount, from the environment.
+struct Paths {
+    mcp: String,
+    health: String,
+    sse: String,
+    messages: String,
+}
+
+/// Send the server's notifications (list_changed, progress, client log
+/// messages) and server-to-client requests out on `streams`.
+fn streamed(builder: ServerBuilder, streams: &Arc<Streams>) -> ServerBuilder {
+    let (notes, requests) = (Arc::clone(streams), Arc::clone(streams));
+    builder
+        .on_notification(move |n: &JsonRpcNotification| notes.send(n.session.as_deref(), n))
+        .on_request(move |r: &ServerRequest| requests.send(Some(r.session.as_str()), r))
+}
+
+/// Start the server's background tasks.
+fn spawn_upkeep(state: &Arc<AppState>, keepalive: Option<Duration>) {
+    // The library has no timers: fail server-to-client requests that the
+    // client never answered, and end sessions past their maximum age.
+    let sweeper = Arc::clone(state);
+    tokio::spawn(async move {
+        let mut tick = tokio::time::interval(Duration::from_secs(5));
+        loop {
+            tick.tick().await;
+            sweeper.server.expire_requests();
+            sweeper.expire_sessions().await;
+        }
+    });
+
+    // Ping clients that hold a stream open but have been quiet for
+    // `keepalive`, and end the sessions of those that don't answer within
+    // the same interval: their stream is usually a dead connection.
+    if let Some(every) = keepalive {
+        let pinger = Arc::clone(state);
+        tokio::spawn(async move {
+            let mut tick = tokio::time::interval(every);
+            loop {
+                tick.tick().await;
+                for sid in pinger.idle_streams(every).await {
+                    let pinger = Arc::clone(&pinger);
+                    tokio::spawn(async move {
+                        let ping = pinger.server.request(&sid, "ping", None);
+                        if !matches!(tokio::time::timeout(every, ping).await, Ok(Ok(_))) {
+                            tracing::info!(session = %sid, "no answer to ping");
+                            pinger.end_session(&sid, None).await;
+                        }
+                    });
+                }
+            }
+        });
+    }
+}
+
+/// The server's routes under `prefix` (empty for the root).  Each mounted
+/// server has its own sessions and streams; a session ID from one is
+/// unknown to the others.
+fn mount(prefix: &str, state: Arc<AppState>, paths: &Paths) -> Router {
+    let endpoint = format!("{}{}", prefix, paths.messages);
+    let routes = Router::new()
+        .route(
+            &paths.health,
+            get(|State(state): State<Arc<AppState>>| async move { Json(state.server.health()) }),
+        )
+        .route(
+            &paths.mcp,
+            post(handle_mcp)
+                .get(open_stream)
+                .delete(delete_session)
+                .fallback(method_not_allowed),
+        )
+        // The legacy transport, for clients that predate Streamable HTTP.
+        .route(
+            &paths.sse,
+            get(move |State(state): State<Arc<AppState>>| {
+                let endpoint = endpoint.clone();
+                async move { open_legacy_stream(state, &endpoint).await }
+            }),
+        )
+        .route(&paths.messages, post(post_legacy_message))
+        .with_state(state);
+    // `nest` rejects an empty prefix, so only nest when one is set.
+    match prefix {
+        "" => routes,
+        prefix => Router::new().nest(prefix, routes),
+    }
+}
+
+#[tokio::main]
+async fn main() {
+    tracing_subscriber::fmt::init();
+
+    // Build the MCP server (pure protocol handler — no HTTP awareness).
+    let streams = Arc::new(Streams::default());
+    let mut server = streamed(Server::builder(), &streams)
+        .tools_file("examples/tools.json")
+        .resources_file("examples/resources.json")
+        .server_info("example-server", "0.1.0")
+        .build();
+
+    server.handle_tool("echo", Arc::new(EchoHandler));
+
+    // Closure-based handler — context is available but unused here.
+    server.handle_tool(
+        "greet",
+        FnToolHandler::new(|args: Value, _context: Value| async move {
+            let name = args
+                .get("name")
+                .and_then(|v| v.as_str())
+                .unwrap_or("world");
+            let style = args
+                .get("style")
+                .and_then(|v| v.as_str())
+                .unwrap_or("casual");
+            let greeting = match style {
+                "formal" => format!("Good day, {}.", name),
+                _ => format!("Hey, {}!", name),
+            };
+            Ok(text_result(greeting))
+        }),
+    );
+
+    // Handler that uses context to read the caller's identity.
+    server.handle_tool(
+        "geocode",
+        FnToolHandler::new(|args: Value, context: Value| async move {
+            // Example: read user_id from decoded JWT claims in context.
+            let _user_id = context
+                .get("user_id")
+                .and_then(|v| v.as_str())
+                .unwrap_or("anonymous");
+
+            if let Some(address) = args.get("address").and_then(|v| v.as_str()) {
+                Ok(text_result(format!(
+                    "Geocoded '{}': lat=40.7128, lon=-74.0060",
+                    address
+                )))
+            } else {
+                let lat = args.get("lat").and_then(|v| v.as_f64()).unwrap_or(0.0);
+                let lon = args.get("lon").and_then(|v| v.as_f64()).unwrap_or(0.0);
+                Ok(text_result(format!(
+                    "Reverse geocode ({}, {}): 123 Main St",
+                    lat, lon
+                )))
+            }
+        }),
+    );
+
+    server.handle_resource("config", Arc::new(ConfigHandler));
+
+    // A second, smaller server in the same process, mounted under /status
+    // with its own tools, sessions and streams.
+    let status_streams = Arc::new(Streams::default());
+    let mut status_server = streamed(Server::builder(), &status_streams)
+        .tools_json(br#"[{"name": "uptime", "description": "Seconds since the process started",
+            "inputSchema": {"type": "object"}}]"#)
+        .server_info("status-server", "0.1.0")
+        .build();
+    let started = Instant::now();
+    status_server.handle_tool(
+        "uptime",
+        FnToolHandler::new(move |_args: Value, _context: Value| async move {
+            Ok(text_result(format!("up {}s", started.elapsed().as_secs())))
+        }),
+    );
+
+    // Wire up the HTTP layer — you own the routes, middleware, and status codes.
+    let settings = Settings::from_env();
+    let state = AppState::new(server, streams, &settings);
+    let status = AppState::new(status_server, status_streams, &settings);
+
+    spawn_upkeep(&state, settings.keepalive);
+    spawn_upkeep(&status, settings.keepalive);
+
+    // Route paths come from the environment, so the server can sit behind
+    // a prefix such as /api/v1 or an API Gateway stage without a rewrite.
+    let path = |var: &str, default: &str| {
+        std::env::var(var).unwrap_or_else(|_| default.to_string())
+    };
+    let base_path = path("MCP_BASE_PATH", "").trim_end_matches('/').to_string();
+    let paths = Paths {
+        mcp: path("MCP_PATH", "/mcp"),
+        health: path("MCP_HEALTH_PATH", "/healthz"),
+        sse: path("MCP_SSE_PATH", "/sse"),
+        messages: path("MCP_MESSAGES_PATH", "/messages"),
+    };
+    let status_path = format!("{}/status", base_path);
+    let routes = mount(&base_path, Arc::clone(&state), &paths)
+        .merge(mount(&status_path, Arc::clone(&status), &paths));
+    // gzip or br, whichever the client's Accept-Encoding prefers; a large
+    // tools/list shrinks several-fold.  The access log sits outside, so it
+    // sees the response as sent.
+    let app = routes
+        .layer(CompressionLayer::new())
+        .layer(middleware::from_fn(access_log));
+
+    let tls = match (std::env::var("TLS_CERT"), std::env::var("TLS_KEY")) {
+        (Ok(cert), Ok(key)) => {
+            let client_ca = std::env::var("TLS_CLIENT_CA").ok();
+            Some(tls_config(&cert, &key, client_ca.as_deref()).expect("TLS configuration"))
+        }
+        _ => None,
+    };
+
+    let scheme = if tls.is_some() { "https" } else { "http" };
+    println!("MCP server listening on {}://localhost:3000", scheme);
+    let Paths { mcp, health, sse, messages } = &paths;
+    println!("  POST   {}{} — MCP JSON-RPC endpoint", base_path, mcp);
+    println!("  GET    {}{} — the session's notification stream (SSE)", base_path, mcp);
+    println!("  DELETE {}{} — end the session in the mcp-session-id header", base_path, mcp);
+    println!("  GET    {}{} — legacy HTTP+SSE stream", base_path, sse);
+    println!("  POST   {}{} — legacy HTTP+SSE messages", base_path, messages);
+    println!("  GET    {}{} — health check", base_path, health);
+    println!("  ...and the same under {} for the status server", status_path);
+
+    // On Ctrl-C, end every session with a final expiring notification.
+    // That also closes the event streams, which graceful shutdown would
+    // otherwise wait on forever.
+    let shutdown = async move {
+        tokio::signal::ctrl_c().await.ok();
+        for state in [state, status] {
+            let ids: Vec<String> = state.sessions.read().await.keys().cloned().collect();
+            for sid in ids {
+                state.end_session(&sid, Some("shutdown")).await;
+            }
+        }
+    };
+    match tls {
+        Some(config) => {
+            let addr = std::net::SocketAddr::from(([0, 0, 0, 0], 3000));
+            let handle = axum_server::Handle::new();
+            let drain = handle.clone();
+            tokio::spawn(async move {
+                shutdown.await;
+                drain.graceful_shutdown(Some(Duration::from_secs(10)));
+            });
+            axum_server::bind_rustls(addr, RustlsConfig::from_config(Arc::new(config)))
+                .handle(handle)
+                .serve(app.into_make_service())
+                .await
+                .unwrap();
+        }
+        None => {
+            let listener = tokio::net::TcpListener::bind("0.0.0.0:3000").await.unwrap();
+            axum::serve(listener, app).with_graceful_shutdown(shutdown).await.unwrap();
+        }
+    }
+}
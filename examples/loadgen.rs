@@ -0,0 +1,319 @@
+//! Simulated MCP client load generator.
+//!
+//! Drives a configurable mix of requests against a Streamable-HTTP MCP
+//! endpoint and reports per-method latency percentiles and error counts —
+//! useful for sizing memory and concurrency limits before launch.
+//!
+//! Run with (against `cargo run --example basic_server`):
+//!   cargo run --example loadgen -- \
+//!     --url http://localhost:3000/mcp \
+//!     --concurrency 16 --requests 5000 \
+//!     --tools examples/tools.json \
+//!     --mix initialize=1,tools/list=4,echo=10,geocode=5
+//!
+//! Mix entries are `name=weight`.  `initialize`, `ping`, `tools/list` and
+//! `resources/list` are sent as-is; any other name is a `tools/call` for that
+//! tool, using the first of its `examples` from `--tools` as arguments (or
+//! `{}` when none are given).
+
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant, SystemTime};
+
+use serde_json::{json, Value};
+
+// ── Configuration ──
+
+struct Config {
+    url: String,
+    concurrency: usize,
+    requests: usize,
+    mix: Vec<(String, u32)>,
+    tool_args: HashMap<String, Value>,
+}
+
+fn parse_args() -> Result<Config, String> {
+    let mut url = "http://localhost:3000/mcp".to_string();
+    let mut concurrency = 8;
+    let mut requests = 1000;
+    let mut mix = "initialize=1,tools/list=4".to_string();
+    let mut tools_path = None;
+
+    let mut args = std::env::args().skip(1);
+    while let Some(flag) = args.next() {
+        let value = args.next().ok_or_else(|| format!("{} needs a value", flag))?;
+        match flag.as_str() {
+            "--url" => url = value,
+            "--concurrency" => concurrency = value.parse().map_err(|e| format!("--concurrency: {}", e))?,
+            "--requests" => requests = value.parse().map_err(|e| format!("--requests: {}", e))?,
+            "--mix" => mix = value,
+            "--tools" => tools_path = Some(value),
+            _ => return Err(format!("unknown flag {}", flag)),
+        }
+    }
+
+    let mut weighted = Vec::new();
+    for entry in mix.split(',').filter(|e| !e.is_empty()) {
+        let (name, weight) = entry
+            .split_once('=')
+            .ok_or_else(|| format!("mix entry {:?} must be name=weight", entry))?;
+        let weight: u32 = weight
+            .parse()
+            .map_err(|e| format!("mix weight for {}: {}", name, e))?;
+        if weight > 0 {
+            weighted.push((name.to_string(), weight));
+        }
+    }
+    if weighted.is_empty() {
+        return Err("--mix has no entries with positive weight".into());
+    }
+
+    let mut tool_args = HashMap::new();
+    if let Some(path) = tools_path {
+        let tools = mcpserver::load_tools(&path).map_err(|e| format!("{}: {}", path, e))?;
+        for tool in tools {
+            let args = tool.examples.first().cloned().unwrap_or_else(|| json!({}));
+            tool_args.insert(tool.name, args);
+        }
+    }
+
+    Ok(Config {
+        url,
+        concurrency: concurrency.max(1),
+        requests,
+        mix: weighted,
+        tool_args,
+    })
+}
+
+// ── Request construction ──
+
+fn build_request(name: &str, id: usize, tool_args: &HashMap<String, Value>) -> Value {
+    match name {
+        "initialize" => json!({
+            "jsonrpc": "2.0", "id": id, "method": "initialize",
+            "params": {
+                "protocolVersion": mcpserver::PROTOCOL_VERSION,
+                "capabilities": {},
+                "clientInfo": {"name": "mcp-loadgen", "version": "0.1.0"},
+            },
+        }),
+        "ping" | "tools/list" | "resources/list" => {
+            json!({"jsonrpc": "2.0", "id": id, "method": name})
+        }
+        tool => json!({
+            "jsonrpc": "2.0", "id": id, "method": "tools/call",
+            "params": {
+                "name": tool,
+                "arguments": tool_args.get(tool).cloned().unwrap_or_else(|| json!({})),
+            },
+        }),
+    }
+}
+
+/// Small xorshift PRNG — good enough to pick weighted entries without
+/// pulling in a `rand` dependency.
+struct Rng(u64);
+
+impl Rng {
+    fn seeded(worker: usize) -> Self {
+        let nanos = SystemTime::now()
+            .duration_since(SystemTime::UNIX_EPOCH)
+            .map(|d| d.as_nanos() as u64)
+            .unwrap_or(0);
+        Rng(nanos ^ (worker as u64 + 1).wrapping_mul(0x9e37_79b9_7f4a_7c15) | 1)
+    }
+
+    fn next(&mut self) -> u64 {
+        self.0 ^= self.0 << 13;
+        self.0 ^= self.0 >> 7;
+        self.0 ^= self.0 << 17;
+        self.0
+    }
+
+    fn pick<'a>(&mut self, mix: &'a [(String, u32)], total: u32) -> &'a str {
+        let mut roll = (self.next() % total as u64) as u32;
+        for (name, weight) in mix {
+            if roll < *weight {
+                return name;
+            }
+            roll -= weight;
+        }
+        &mix[mix.len() - 1].0
+    }
+}
+
+// ── Measurement ──
+
+#[derive(Default)]
+struct Stats {
+    latencies: Vec<Duration>,
+    errors: usize,
+}
+
+type Recorder = Arc<Mutex<HashMap<String, Stats>>>;
+
+fn record(recorder: &Recorder, name: &str, elapsed: Duration, ok: bool) {
+    let mut stats = recorder.lock().unwrap();
+    let entry = stats.entry(name.to_string()).or_default();
+    entry.latencies.push(elapsed);
+    if !ok {
+        entry.errors += 1;
+    }
+}
+
+/// A response is a failure when the HTTP status is not 2xx, the body carries
+/// a JSON-RPC error, or a tool result is flagged `isError`.
+fn is_success(status: reqwest::StatusCode, body: &[u8]) -> bool {
+    if !status.is_success() {
+        return false;
+    }
+    if body.is_empty() {
+        return true;
+    }
+    match serde_json::from_slice::<Value>(body) {
+        Ok(v) => v.get("error").is_none() && v["result"]["isError"] != json!(true),
+        Err(_) => false,
+    }
+}
+
+fn percentile(sorted: &[Duration], p: f64) -> Duration {
+    if sorted.is_empty() {
+        return Duration::ZERO;
+    }
+    let rank = ((p / 100.0) * (sorted.len() - 1) as f64).round() as usize;
+    sorted[rank.min(sorted.len() - 1)]
+}
+
+fn report(recorder: &Recorder, wall: Duration) {
+    let mut stats = recorder.lock().unwrap();
+    let mut names: Vec<String> = stats.keys().cloned().collect();
+    names.sort();
+
+    println!(
+        "{:<20} {:>8} {:>7} {:>9} {:>9} {:>9} {:>9}",
+        "method", "count", "errors", "p50", "p95", "p99", "max"
+    );
+    let mut total = 0;
+    let mut total_errors = 0;
+    for name in names {
+        let entry = stats.get_mut(&name).unwrap();
+        entry.latencies.sort();
+        let l = &entry.latencies;
+        total += l.len();
+        total_errors += entry.errors;
+        println!(
+            "{:<20} {:>8} {:>7} {:>9.1?} {:>9.1?} {:>9.1?} {:>9.1?}",
+            name,
+            l.len(),
+            entry.errors,
+            percentile(l, 50.0),
+            percentile(l, 95.0),
+            percentile(l, 99.0),
+            l.last().copied().unwrap_or_default(),
+        );
+    }
+    println!(
+        "\n{} requests, {} errors in {:.2?} ({:.1} req/s)",
+        total,
+        total_errors,
+        wall,
+        total as f64 / wall.as_secs_f64().max(f64::EPSILON)
+    );
+}
+
+// ── Workers ──
+
+async fn worker(
+    worker_id: usize,
+    client: reqwest::Client,
+    config: Arc<Config>,
+    next_request: Arc<AtomicUsize>,
+    next_id: Arc<AtomicU64>,
+    recorder: Recorder,
+) {
+    let total_weight: u32 = config.mix.iter().map(|(_, w)| w).sum();
+    let mut rng = Rng::seeded(worker_id);
+    let mut session: Option<String> = None;
+
+    while next_request.fetch_add(1, Ordering::Relaxed) < config.requests {
+        // Every simulated client opens its session before anything else.
+        let name = if session.is_none() {
+            "initialize"
+        } else {
+            rng.pick(&config.mix, total_weight)
+        };
+        let id = next_id.fetch_add(1, Ordering::Relaxed) as usize;
+        let body = build_request(name, id, &config.tool_args);
+
+        let mut req = client.post(&config.url).json(&body);
+        if let Some(sid) = &session {
+            req = req.header("mcp-session-id", sid);
+        }
+
+        let started = Instant::now();
+        let ok = match req.send().await {
+            Ok(resp) => {
+                if let Some(sid) = resp.headers().get("mcp-session-id").and_then(|v| v.to_str().ok()) {
+                    session = Some(sid.to_string());
+                } else if name == "initialize" && session.is_none() {
+                    // Servers without session management still count as
+                    // initialized; keep the mix moving.
+                    session = Some(String::new());
+                }
+                let status = resp.status();
+                match resp.bytes().await {
+                    Ok(bytes) => is_success(status, &bytes),
+                    Err(_) => false,
+                }
+            }
+            Err(_) => false,
+        };
+        record(&recorder, name, started.elapsed(), ok);
+    }
+}
+
+#[tokio::main]
+async fn main() {
+    let config = match parse_args() {
+        Ok(c) => Arc::new(c),
+        Err(e) => {
+            eprintln!("mcp-loadgen: {}", e);
+            std::process::exit(2);
+        }
+    };
+
+    println!(
+        "{} requests against {} with {} workers",
+        config.requests, config.url, config.concurrency
+    );
+
+    let client = reqwest::Client::builder()
+        .pool_max_idle_per_host(config.concurrency)
+        .timeout(Duration::from_secs(30))
+        .build()
+        .expect("build http client");
+    let next_request = Arc::new(AtomicUsize::new(0));
+    let next_id = Arc::new(AtomicU64::new(1));
+    let recorder: Recorder = Arc::new(Mutex::new(HashMap::new()));
+
+    let started = Instant::now();
+    let handles: Vec<_> = (0..config.concurrency)
+        .map(|i| {
+            tokio::spawn(worker(
+                i,
+                client.clone(),
+                Arc::clone(&config),
+                Arc::clone(&next_request),
+                Arc::clone(&next_id),
+                Arc::clone(&recorder),
+            ))
+        })
+        .collect();
+    for handle in handles {
+        let _ = handle.await;
+    }
+
+    report(&recorder, started.elapsed());
+}
@@ -0,0 +1,307 @@
+//! Catalog tooling for `tools.json` files.
+//!
+//! Run with: `cargo run --example mcpgen -- verify examples/tools.json`
+//!
+//! Subcommands:
+//!   verify <tools.json>   Check every tool's `examples` pass validation and
+//!                         every `counterexamples` entry fails it.  Exits
+//!                         non-zero on any contract violation, so it can run
+//!                         in CI next to the catalog.
+//!   init <dir>            Scaffold a runnable Axum server crate in a new
+//!                         directory: Cargo.toml, a sample tools.json, typed
+//!                         handlers and tests that go through the protocol.
+
+use std::path::Path;
+use std::process::ExitCode;
+
+use mcpserver::load_tools;
+
+fn verify(path: &str) -> ExitCode {
+    let tools = match load_tools(path) {
+        Ok(tools) => tools,
+        Err(e) => {
+            eprintln!("{}: {}", path, e);
+            return ExitCode::FAILURE;
+        }
+    };
+
+    let mut checked = 0;
+    let mut failures = Vec::new();
+    for tool in &tools {
+        checked += tool.examples.len() + tool.counterexamples.len();
+        failures.extend(tool.verify_examples());
+    }
+
+    for failure in &failures {
+        eprintln!("FAIL {}", failure);
+    }
+    println!(
+        "{}: {} tools, {} examples checked, {} failures",
+        path,
+        tools.len(),
+        checked,
+        failures.len()
+    );
+
+    if failures.is_empty() {
+        ExitCode::SUCCESS
+    } else {
+        ExitCode::FAILURE
+    }
+}
+
+// ── init templates; `{{name}}` is replaced with the crate name ──
+
+const CARGO_TOML: &str = r#"[package]
+name = "{{name}}"
+version = "0.1.0"
+edition = "2024"
+
+[dependencies]
+mcpserver = "0.4"
+axum = "0.8"
+serde = { version = "1", features = ["derive"] }
+serde_json = "1"
+tokio = { version = "1", features = ["full"] }
+uuid = { version = "1", features = ["v4"] }
+"#;
+
+const TOOLS_JSON: &str = r#"[
+  {
+    "name": "greet",
+    "description": "Greet someone by name",
+    "inputSchema": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "description": "Who to greet"},
+        "excited": {"type": "boolean"}
+      },
+      "required": ["name"]
+    },
+    "annotations": {"readOnlyHint": true},
+    "examples": [{"name": "Ada"}, {"name": "Ada", "excited": true}],
+    "counterexamples": [{}]
+  }
+]
+"#;
+
+const MAIN_RS: &str = r#"//! {{name}}: an MCP server over Streamable HTTP.
+//!
+//! Run with `cargo run`, then:
+//!   curl -X POST http://localhost:3000/mcp -H "Content-Type: application/json" \
+//!     -d '{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}'
+//!
+//! Check the catalog's examples with `mcpgen verify tools.json`.
+
+mod handlers;
+
+use std::collections::HashSet;
+use std::sync::Arc;
+
+use axum::body::{Body, Bytes};
+use axum::extract::State;
+use axum::http::{HeaderMap, StatusCode};
+use axum::response::{IntoResponse, Response};
+use axum::routing::{get, post};
+use axum::{Json, Router};
+use mcpserver::types::ERR_CODE_SESSION_NOT_FOUND;
+use mcpserver::{new_error_response, parse_request, FnToolHandler, Server, ServerBuilder};
+use serde_json::json;
+use tokio::sync::RwLock;
+
+/// The catalog and handlers, shared by `main` and the tests.
+fn build_server(builder: ServerBuilder) -> Server {
+    let mut server = builder
+        .tools_json(include_bytes!("../tools.json"))
+        .server_info("{{name}}", env!("CARGO_PKG_VERSION"))
+        .build();
+    server.handle_tool("greet", FnToolHandler::new(handlers::greet));
+    server
+}
+
+struct AppState {
+    server: Server,
+    sessions: RwLock<HashSet<String>>,
+}
+
+async fn handle_mcp(State(state): State<Arc<AppState>>, headers: HeaderMap, body: Bytes) -> Response {
+    let req = match parse_request(&body) {
+        Ok(req) => req,
+        Err(err) => return (StatusCode::BAD_REQUEST, Json(err)).into_response(),
+    };
+
+    let session_id = if req.method == "initialize" {
+        let id = uuid::Uuid::new_v4().to_string();
+        state.sessions.write().await.insert(id.clone());
+        Some(id)
+    } else {
+        headers.get("mcp-session-id").and_then(|h| h.to_str().ok()).map(String::from)
+    };
+    if let Some(sid) = &session_id {
+        if !state.sessions.read().await.contains(sid) {
+            let err = new_error_response(None, ERR_CODE_SESSION_NOT_FOUND, "Session not found");
+            return (StatusCode::NOT_FOUND, Json(err)).into_response();
+        }
+    }
+
+    // Add decoded auth claims here; handlers receive this as `context`.
+    let context = match &session_id {
+        Some(sid) => json!({"sessionId": sid}),
+        None => json!({}),
+    };
+    let resp = state.server.handle(req, context).await;
+    if resp.is_notification() {
+        return (StatusCode::ACCEPTED, Body::empty()).into_response();
+    }
+    let mut response = Json(&resp).into_response();
+    if let Some(sid) = session_id {
+        response.headers_mut().insert("mcp-session-id", sid.parse().unwrap());
+    }
+    response
+}
+
+async fn delete_session(State(state): State<Arc<AppState>>, headers: HeaderMap) -> StatusCode {
+    let sid = headers.get("mcp-session-id").and_then(|h| h.to_str().ok()).unwrap_or_default();
+    if !state.sessions.write().await.remove(sid) {
+        return StatusCode::NOT_FOUND;
+    }
+    state.server.end_session(sid);
+    StatusCode::NO_CONTENT
+}
+
+#[tokio::main]
+async fn main() {
+    let state = Arc::new(AppState {
+        server: build_server(Server::builder()),
+        sessions: RwLock::new(HashSet::new()),
+    });
+    let app = Router::new()
+        .route("/healthz", get(|State(state): State<Arc<AppState>>| async move { Json(state.server.health()) }))
+        .route("/mcp", post(handle_mcp).delete(delete_session))
+        .with_state(state);
+
+    let addr = std::env::var("ADDR").unwrap_or_else(|_| "0.0.0.0:3000".into());
+    let listener = tokio::net::TcpListener::bind(&addr).await.unwrap();
+    println!("{{name}} listening on http://{}/mcp", addr);
+    axum::serve(listener, app).await.unwrap();
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use mcpserver::MemoryTransport;
+
+    #[tokio::test]
+    async fn test_greet_over_the_protocol() {
+        let transport = MemoryTransport::new();
+        let server = Arc::new(build_server(transport.attach(Server::builder())));
+        let client = transport.connect(&server, json!({}));
+        assert!(client.initialize(json!({})).await.error.is_none());
+
+        let params = json!({"name": "greet", "arguments": {"name": "Ada"}});
+        let resp = client.request("tools/call", Some(params)).await;
+        assert_eq!(resp.result.unwrap()["content"][0]["text"], "Hello, Ada.");
+
+        // Arguments are checked against the inputSchema before the handler runs.
+        let resp = client.request("tools/call", Some(json!({"name": "greet", "arguments": {}}))).await;
+        assert!(resp.error.is_some());
+    }
+}
+"#;
+
+const HANDLERS_RS: &str = r#"//! Tool handlers.  Each takes the call's arguments and the request
+//! context, and is registered in `build_server`.
+
+use mcpserver::{text_result, McpError, ToolResult};
+use serde::Deserialize;
+use serde_json::Value;
+
+/// Arguments of `greet`, matching its inputSchema in tools.json.
+#[derive(Debug, Deserialize)]
+struct GreetArgs {
+    name: String,
+    #[serde(default)]
+    excited: bool,
+}
+
+pub async fn greet(args: Value, _context: Value) -> Result<ToolResult, McpError> {
+    let args: GreetArgs = serde_json::from_value(args)?;
+    let end = if args.excited { "!" } else { "." };
+    Ok(text_result(format!("Hello, {}{}", args.name, end)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[tokio::test]
+    async fn test_greet() {
+        let result = greet(json!({"name": "Ada", "excited": true}), json!({})).await.unwrap();
+        assert_eq!(result.content[0].text.as_deref(), Some("Hello, Ada!"));
+    }
+}
+"#;
+
+const GITIGNORE: &str = "/target\n";
+
+/// A crate name from the directory name: lowercase ASCII letters, digits
+/// and dashes.
+fn crate_name(dir: &Path) -> String {
+    let base = dir.file_name().and_then(|n| n.to_str()).unwrap_or("mcp-server");
+    let name: String = base
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c.to_ascii_lowercase() } else { '-' })
+        .collect();
+    match name.trim_matches('-') {
+        "" => "mcp-server".to_string(),
+        name if name.starts_with(|c: char| c.is_ascii_digit()) => format!("mcp-{}", name),
+        name => name.to_string(),
+    }
+}
+
+fn init(dir: &str) -> ExitCode {
+    let dir = Path::new(dir);
+    if dir.read_dir().is_ok_and(|mut entries| entries.next().is_some()) {
+        eprintln!("{}: directory exists and is not empty", dir.display());
+        return ExitCode::FAILURE;
+    }
+    let name = crate_name(dir);
+    let files = [
+        ("Cargo.toml", CARGO_TOML),
+        ("tools.json", TOOLS_JSON),
+        ("src/main.rs", MAIN_RS),
+        ("src/handlers.rs", HANDLERS_RS),
+        (".gitignore", GITIGNORE),
+    ];
+    for (path, template) in files {
+        let path = dir.join(path);
+        let written = path
+            .parent()
+            .map_or(Ok(()), std::fs::create_dir_all)
+            .and_then(|_| std::fs::write(&path, template.replace("{{name}}", &name)));
+        if let Err(e) = written {
+            eprintln!("{}: {}", path.display(), e);
+            return ExitCode::FAILURE;
+        }
+    }
+
+    println!("created {} in {}", name, dir.display());
+    println!("  cd {} && cargo test && cargo run", dir.display());
+    ExitCode::SUCCESS
+}
+
+fn usage() -> ExitCode {
+    eprintln!("usage: mcpgen verify <tools.json>");
+    eprintln!("       mcpgen init <dir>");
+    ExitCode::from(2)
+}
+
+fn main() -> ExitCode {
+    let args: Vec<String> = std::env::args().skip(1).collect();
+    match args.iter().map(String::as_str).collect::<Vec<_>>().as_slice() {
+        ["verify", path] => verify(path),
+        ["init", dir] => init(dir),
+        _ => usage(),
+    }
+}
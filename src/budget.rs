@@ -0,0 +1,131 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+
+/// Number of recent calls kept per tool for the rolling p95.
+const WINDOW: usize = 100;
+
+/// Minimum samples before a p95 is trusted; avoids alerting on one slow
+/// cold-start call.
+const MIN_SAMPLES: usize = 20;
+
+/// Raised when a tool's rolling p95 latency exceeds its declared budget.
+#[derive(Debug, Clone, PartialEq)]
+pub struct LatencyAlert {
+    pub tool: String,
+    pub budget: Duration,
+    pub p95: Duration,
+    pub samples: usize,
+}
+
+/// Callback invoked with each [`LatencyAlert`].
+pub type LatencyAlertFn = Arc<dyn Fn(&LatencyAlert) + Send + Sync>;
+
+#[derive(Default)]
+struct Window {
+    samples: VecDeque<Duration>,
+    /// True while the tool is over budget — alerts fire on the transition
+    /// into this state, not on every slow call.
+    breached: bool,
+}
+
+/// Tracks rolling per-tool latencies and fires alerts on budget breaches.
+#[derive(Default)]
+pub(crate) struct LatencyTracker {
+    windows: Mutex<HashMap<String, Window>>,
+}
+
+impl LatencyTracker {
+    /// Record one call and return an alert if this call pushed the tool's
+    /// p95 over budget.  Returns `None` while the tool stays over budget;
+    /// the alert re-arms once the p95 drops back under.
+    pub fn record(&self, tool: &str, budget: Duration, elapsed: Duration) -> Option<LatencyAlert> {
+        let mut windows = self.windows.lock().unwrap_or_else(|e| e.into_inner());
+        let window = windows.entry(tool.to_string()).or_default();
+
+        window.samples.push_back(elapsed);
+        if window.samples.len() > WINDOW {
+            window.samples.pop_front();
+        }
+        if window.samples.len() < MIN_SAMPLES {
+            return None;
+        }
+
+        let p95 = p95(&window.samples);
+        let over = p95 > budget;
+        let alert = (over && !window.breached).then(|| LatencyAlert {
+            tool: tool.to_string(),
+            budget,
+            p95,
+            samples: window.samples.len(),
+        });
+        window.breached = over;
+        alert
+    }
+}
+
+fn p95(samples: &VecDeque<Duration>) -> Duration {
+    let mut sorted: Vec<Duration> = samples.iter().copied().collect();
+    sorted.sort();
+    let rank = (sorted.len() * 95).div_ceil(100).saturating_sub(1);
+    sorted[rank]
+}
+
+/// Default alert sink: log at error level.
+pub(crate) fn log_alert(alert: &LatencyAlert) {
+    tracing::error!(
+        tool = %alert.tool,
+        budget_ms = alert.budget.as_millis() as u64,
+        p95_ms = alert.p95.as_millis() as u64,
+        samples = alert.samples,
+        "tool exceeds latency budget"
+    );
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const BUDGET: Duration = Duration::from_millis(100);
+
+    #[test]
+    fn test_no_alert_below_min_samples() {
+        let tracker = LatencyTracker::default();
+        for _ in 0..MIN_SAMPLES - 1 {
+            assert!(tracker.record("t", BUDGET, Duration::from_secs(1)).is_none());
+        }
+    }
+
+    #[test]
+    fn test_alert_fires_once_and_rearms() {
+        let tracker = LatencyTracker::default();
+        for _ in 0..MIN_SAMPLES - 1 {
+            tracker.record("t", BUDGET, Duration::from_millis(500));
+        }
+        let alert = tracker.record("t", BUDGET, Duration::from_millis(500)).unwrap();
+        assert_eq!(alert.tool, "t");
+        assert_eq!(alert.p95, Duration::from_millis(500));
+
+        // Still over budget — no repeated alert.
+        assert!(tracker.record("t", BUDGET, Duration::from_millis(500)).is_none());
+
+        // Recover, then breach again.
+        for _ in 0..WINDOW {
+            tracker.record("t", BUDGET, Duration::from_millis(10));
+        }
+        let mut fired = false;
+        for _ in 0..WINDOW {
+            fired |= tracker.record("t", BUDGET, Duration::from_millis(500)).is_some();
+        }
+        assert!(fired);
+    }
+
+    #[test]
+    fn test_occasional_slow_call_within_budget() {
+        let tracker = LatencyTracker::default();
+        for i in 0..WINDOW {
+            let elapsed = if i % 50 == 0 { Duration::from_secs(2) } else { Duration::from_millis(5) };
+            assert!(tracker.record("t", BUDGET, elapsed).is_none());
+        }
+    }
+}
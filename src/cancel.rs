@@ -0,0 +1,305 @@
+use std::collections::HashMap;
+use std::future::Future;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::pin::Pin;
+use std::sync::{Arc, Mutex};
+use std::task::{Poll, Waker};
+use std::time::Duration;
+
+use serde_json::Value;
+
+use crate::timer::{Scheduled, Timer};
+
+/// Cancellation flag for one in-flight request.
+#[derive(Default)]
+pub(crate) struct CancelToken {
+    cancelled: AtomicBool,
+    waker: Mutex<Option<Waker>>,
+}
+
+impl CancelToken {
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::SeqCst);
+        if let Some(waker) = self.waker.lock().unwrap_or_else(|e| e.into_inner()).take() {
+            waker.wake();
+        }
+    }
+
+    pub fn is_cancelled(&self) -> bool {
+        self.cancelled.load(Ordering::SeqCst)
+    }
+
+    fn register(&self, waker: &Waker) {
+        *self.waker.lock().unwrap_or_else(|e| e.into_inner()) = Some(waker.clone());
+    }
+}
+
+/// Run `fut` until it completes or `token` is cancelled.  On cancellation
+/// the future is dropped at its current await point, which is how Rust
+/// stops work without a runtime-specific abort handle.
+pub(crate) async fn cancellable<F: Future>(token: &CancelToken, fut: F) -> Option<F::Output> {
+    let mut fut = std::pin::pin!(fut);
+    std::future::poll_fn(|cx| {
+        token.register(cx.waker());
+        if token.is_cancelled() {
+            return Poll::Ready(None);
+        }
+        fut.as_mut().poll(cx).map(Some)
+    })
+    .await
+}
+
+/// Cancels its token once a timeout passes on the server's [`Timer`].
+/// Dropping the deadline first disarms it.
+pub(crate) struct Deadline {
+    pub token: Arc<CancelToken>,
+    _expiry: Scheduled,
+}
+
+impl Deadline {
+    pub fn start(timer: &Timer, timeout: Duration) -> Self {
+        let token = Arc::new(CancelToken::default());
+        let expire = Arc::clone(&token);
+        let _expiry = timer.schedule(timeout, move || expire.cancel());
+        Deadline { token, _expiry }
+    }
+}
+
+/// Result slot shared between a [`Reply`] and the request awaiting it.
+struct Slot<T> {
+    value: Option<T>,
+    /// The [`Reply`] was dropped.
+    closed: bool,
+    /// The waiting future was dropped.
+    abandoned: bool,
+    waker: Option<Waker>,
+}
+
+/// The sending half of [`reply_channel`], moved to whatever produces the
+/// value (a detached task or a thread).
+pub(crate) struct Reply<T>(Arc<Mutex<Slot<T>>>);
+
+impl<T> Reply<T> {
+    /// True once the waiting future has been dropped.
+    #[cfg(test)]
+    pub fn abandoned(&self) -> bool {
+        self.0.lock().unwrap_or_else(|e| e.into_inner()).abandoned
+    }
+
+    /// Deliver the value, or hand it back if nobody is waiting.  The check
+    /// and the store happen under one lock, so a value is never left in a
+    /// slot whose waiter is already gone.
+    pub fn send(self, value: T) -> Result<(), T> {
+        let mut slot = self.0.lock().unwrap_or_else(|e| e.into_inner());
+        if slot.abandoned {
+            return Err(value);
+        }
+        slot.value = Some(value);
+        Ok(())
+    }
+}
+
+impl<T> Drop for Reply<T> {
+    fn drop(&mut self) {
+        let mut slot = self.0.lock().unwrap_or_else(|e| e.into_inner());
+        slot.closed = true;
+        if let Some(waker) = slot.waker.take() {
+            waker.wake();
+        }
+    }
+}
+
+/// The waiting half of [`reply_channel`].  Dropping it marks the slot
+/// abandoned.
+struct Recv<T>(Arc<Mutex<Slot<T>>>);
+
+impl<T> Future for Recv<T> {
+    type Output = Option<T>;
+
+    fn poll(self: Pin<&mut Self>, cx: &mut std::task::Context<'_>) -> Poll<Option<T>> {
+        let mut slot = self.0.lock().unwrap_or_else(|e| e.into_inner());
+        match slot.value.take() {
+            Some(value) => Poll::Ready(Some(value)),
+            None if slot.closed => Poll::Ready(None),
+            None => {
+                slot.waker = Some(cx.waker().clone());
+                Poll::Pending
+            }
+        }
+    }
+}
+
+impl<T> Drop for Recv<T> {
+    fn drop(&mut self) {
+        self.0.lock().unwrap_or_else(|e| e.into_inner()).abandoned = true;
+    }
+}
+
+/// A runtime-agnostic one-shot channel.  The future resolves to `None` if
+/// the [`Reply`] is dropped without sending.
+pub(crate) fn reply_channel<T>() -> (Reply<T>, impl Future<Output = Option<T>>) {
+    let slot = Arc::new(Mutex::new(Slot {
+        value: None,
+        closed: false,
+        abandoned: false,
+        waker: None,
+    }));
+    (Reply(Arc::clone(&slot)), Recv(slot))
+}
+
+/// Run `fut` as its own task via `spawn` and wait for its output.  If the
+/// waiting future is dropped (the client went away), the task still runs
+/// to completion and `orphaned` sees its output.  Resolves to `None` only
+/// if `spawn` drops the task without running it.
+pub(crate) async fn detached<T, F>(
+    spawn: &(dyn Fn(Pin<Box<dyn Future<Output = ()> + Send>>) + Send + Sync),
+    fut: F,
+    orphaned: impl FnOnce(&T) + Send + 'static,
+) -> Option<T>
+where
+    T: Send + 'static,
+    F: Future<Output = T> + Send + 'static,
+{
+    let (reply, recv) = reply_channel();
+    spawn(Box::pin(async move {
+        if let Err(value) = reply.send(fut.await) {
+            orphaned(&value);
+        }
+    }));
+    recv.await
+}
+
+/// Requests currently being handled, keyed by (session, request ID) so a
+/// client can only cancel its own requests.
+#[derive(Default)]
+pub(crate) struct InFlight {
+    tokens: Mutex<HashMap<(String, String), Arc<CancelToken>>>,
+}
+
+impl InFlight {
+    /// Track a request; it stays cancellable until the guard is dropped.
+    pub fn register(&self, session: &str, id: &Value) -> InFlightGuard<'_> {
+        let key = key(session, id);
+        let token = Arc::new(CancelToken::default());
+        self.lock().insert(key.clone(), Arc::clone(&token));
+        InFlightGuard {
+            registry: self,
+            key,
+            token,
+        }
+    }
+
+    /// Cancel a tracked request.  Returns false if it is unknown or has
+    /// already finished.
+    pub fn cancel(&self, session: &str, id: &Value) -> bool {
+        match self.lock().get(&key(session, id)) {
+            Some(token) => {
+                token.cancel();
+                true
+            }
+            None => false,
+        }
+    }
+
+    #[cfg(test)]
+    pub fn is_empty(&self) -> bool {
+        self.lock().is_empty()
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, HashMap<(String, String), Arc<CancelToken>>> {
+        self.tokens.lock().unwrap_or_else(|e| e.into_inner())
+    }
+}
+
+pub(crate) struct InFlightGuard<'a> {
+    registry: &'a InFlight,
+    key: (String, String),
+    pub token: Arc<CancelToken>,
+}
+
+impl Drop for InFlightGuard<'_> {
+    fn drop(&mut self) {
+        let mut tokens = self.registry.lock();
+        // A reused ID may have replaced our entry; only remove our own.
+        if tokens.get(&self.key).is_some_and(|t| Arc::ptr_eq(t, &self.token)) {
+            tokens.remove(&self.key);
+        }
+    }
+}
+
+fn key(session: &str, id: &Value) -> (String, String) {
+    (session.to_string(), id.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[tokio::test]
+    async fn test_cancel_stops_pending_future() {
+        let in_flight = InFlight::default();
+        let guard = in_flight.register("s", &json!(7));
+        let token = Arc::clone(&guard.token);
+
+        let (out, cancelled) = tokio::join!(cancellable(&token, std::future::pending::<()>()), async {
+            tokio::task::yield_now().await;
+            in_flight.cancel("s", &json!(7))
+        });
+        assert!(out.is_none());
+        assert!(cancelled);
+
+        drop(guard);
+        assert!(!in_flight.cancel("s", &json!(7)));
+    }
+
+    #[tokio::test]
+    async fn test_detached_task_outlives_its_waiter() {
+        let spawn = |fut: Pin<Box<dyn Future<Output = ()> + Send>>| {
+            tokio::spawn(fut);
+        };
+        assert_eq!(detached(&spawn, async { 5 }, |_| {}).await, Some(5));
+
+        let (release, wait) = tokio::sync::oneshot::channel::<()>();
+        let (orphan_tx, orphan_rx) = tokio::sync::oneshot::channel();
+        let waiter = detached(&spawn, async move { wait.await.unwrap(); 6 }, move |v| orphan_tx.send(*v).unwrap());
+        let timed_out = tokio::time::timeout(std::time::Duration::from_millis(10), waiter).await;
+        assert!(timed_out.is_err());
+        release.send(()).unwrap();
+        assert_eq!(orphan_rx.await.unwrap(), 6);
+    }
+
+    #[tokio::test]
+    async fn test_deadline_drops_hung_future() {
+        let clock = Arc::new(crate::clock::ManualClock::new());
+        let timer = Timer::new(clock.clone());
+        let deadline = Deadline::start(&timer, Duration::from_millis(10));
+        let hung = cancellable(&deadline.token, std::future::pending::<()>());
+        let (result, ()) = tokio::join!(hung, async {
+            clock.advance(Duration::from_millis(10));
+        });
+        assert!(result.is_none());
+
+        let deadline = Deadline::start(&timer, Duration::from_millis(10));
+        assert_eq!(cancellable(&deadline.token, async { 5 }).await, Some(5));
+        let token = Arc::clone(&deadline.token);
+        drop(deadline);
+        clock.advance(Duration::from_millis(10));
+        assert!(!token.is_cancelled());
+    }
+
+    #[test]
+    fn test_send_after_receiver_dropped_hands_value_back() {
+        let (reply, recv) = reply_channel::<u8>();
+        assert!(!reply.abandoned());
+        drop(recv);
+        assert!(reply.abandoned());
+        assert_eq!(reply.send(3), Err(3));
+    }
+
+    #[tokio::test]
+    async fn test_completed_future_is_returned() {
+        let token = CancelToken::default();
+        assert_eq!(cancellable(&token, async { 5 }).await, Some(5));
+    }
+}
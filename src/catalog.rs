@@ -0,0 +1,447 @@
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+
+use serde::Serialize;
+use serde_json::value::RawValue;
+use serde_json::{json, Value};
+
+use crate::types::{McpError, Resource, Tool};
+
+/// Immutable snapshot of the tool and resource definitions a server is
+/// serving, together with their pre-serialized list payloads.
+///
+/// The server holds the current snapshot behind `RwLock<Arc<Catalog>>`.
+/// Requests take an `Arc` clone (ref-count only) and work against that
+/// snapshot for their whole lifetime; a reload builds a new snapshot and
+/// swaps the pointer, so in-flight requests are never affected.
+#[derive(Debug)]
+pub(crate) struct Catalog {
+    pub tools: HashMap<String, Tool>,
+    pub resources: HashMap<String, Resource>,
+    /// Names in served (definition) order, so edits keep list order stable.
+    tool_order: Vec<String>,
+    resource_order: Vec<String>,
+    /// Pre-serialized tools/list result.
+    pub tools_list_result: Arc<RawValue>,
+    /// Pre-serialized resources/list result, one entry per page (a single
+    /// page holding everything when pagination is off).
+    pub resources_pages: Vec<Arc<RawValue>>,
+    /// Stable content hash of the list payloads.
+    pub hash: String,
+    /// Whether any tool has `visibleWhen` rules, in which case tools/list
+    /// is built per request instead of served from the cached payload.
+    pub conditional: bool,
+    minimize_schemas: bool,
+}
+
+/// How a snapshot renders its list payloads.
+#[derive(Debug, Clone, Copy, Default)]
+pub(crate) struct ListOptions {
+    /// Entries per resources/list (and prompts/list) page; `None` for one
+    /// page.
+    pub page_size: Option<usize>,
+    /// Strip non-functional keywords from served tool schemas.
+    pub minimize_schemas: bool,
+}
+
+impl Catalog {
+    /// Build an unpaginated snapshot.
+    #[cfg(test)]
+    pub fn new(tools: Vec<Tool>, resources: Vec<Resource>) -> Self {
+        Self::with_options(tools, resources, ListOptions::default())
+    }
+
+    /// Build a snapshot, pre-serializing the list payloads first (borrowing
+    /// the Vecs) and then moving the definitions into lookup maps.
+    pub fn with_options(tools: Vec<Tool>, mut resources: Vec<Resource>, options: ListOptions) -> Self {
+        let resource_order: Vec<String> = resources.iter().map(|r| r.name.clone()).collect();
+        // Resources published from tool output are listed after the static
+        // ones but are not definitions of their own: they follow the tool.
+        for published in tools.iter().filter_map(|t| t.publish_as.as_ref()) {
+            if !resources.iter().any(|r| r.name == published.name) {
+                resources.push(published.clone());
+            }
+        }
+
+        let entries: Vec<Value> = tools.iter().map(|t| tool_entry(t, options.minimize_schemas)).collect();
+        let tools_list_result: Arc<RawValue> = Arc::from(to_raw(&json!({ "tools": entries })));
+        let resources_pages = paginate("resources", &resources, options.page_size);
+        let mut parts = vec![tools_list_result.get()];
+        parts.extend(resources_pages.iter().map(|p| p.get()));
+        let hash = content_hash(&parts);
+        let tool_order = tools.iter().map(|t| t.name.clone()).collect();
+        let conditional = tools
+            .iter()
+            .any(|t| t.visible_when.is_some() || !t.requires_client.is_empty());
+
+        // Only the key String is cloned, the structs themselves are moved.
+        let tools = tools
+            .into_iter()
+            .map(|t| {
+                let name = t.name.clone();
+                (name, t)
+            })
+            .collect();
+        let resources = resources
+            .into_iter()
+            .map(|r| {
+                let name = r.name.clone();
+                (name, r)
+            })
+            .collect();
+
+        Catalog {
+            tools,
+            resources,
+            tool_order,
+            resource_order,
+            tools_list_result,
+            resources_pages,
+            hash,
+            conditional,
+            minimize_schemas: options.minimize_schemas,
+        }
+    }
+
+    /// A tool's tools/list entry as this snapshot serves it.
+    pub fn list_entry(&self, tool: &Tool) -> Value {
+        tool_entry(tool, self.minimize_schemas)
+    }
+
+    /// Tools visible to a session with the given attributes, in served
+    /// order.
+    pub fn visible_tools(&self, attrs: &Value) -> Vec<&Tool> {
+        self.tool_order
+            .iter()
+            .filter_map(|name| self.tools.get(name))
+            .filter(|t| t.visible_when.as_ref().is_none_or(|v| v.allows(attrs)))
+            .collect()
+    }
+
+    /// Clone the definitions back out in served order — the starting point
+    /// for incremental edits (add/remove one tool or resource).
+    pub fn definitions(&self) -> (Vec<Tool>, Vec<Resource>) {
+        let tools = self
+            .tool_order
+            .iter()
+            .filter_map(|name| self.tools.get(name).cloned())
+            .collect();
+        let resources = self
+            .resource_order
+            .iter()
+            .filter_map(|name| self.resources.get(name).cloned())
+            .collect();
+        (tools, resources)
+    }
+}
+
+/// Keywords that neither validate nor help a model use the tool:
+/// authoring comments, sample values and vendor `x-` extensions.
+fn non_functional(key: &str) -> bool {
+    matches!(key, "$comment" | "examples") || key.starts_with("x-")
+}
+
+/// Keywords that only annotate and can be dropped without changing what
+/// validates.
+fn annotation(key: &str) -> bool {
+    non_functional(key) || matches!(key, "title" | "description" | "default" | "deprecated")
+}
+
+/// A tool's tools/list entry, optionally with non-functional schema
+/// keywords removed.  Validation uses the parsed metadata, so it is
+/// unaffected.
+fn tool_entry(tool: &Tool, minimize: bool) -> Value {
+    let mut entry = serde_json::to_value(tool).unwrap_or(Value::Null);
+    if minimize {
+        strip_schemas(&mut entry, non_functional);
+    }
+    entry
+}
+
+/// A tool's list entry for clients with small context windows: the
+/// description cut to `max_description_len` characters and annotation
+/// keywords stripped from its schemas.
+pub(crate) fn compact_tool(tool: &Tool, max_description_len: usize) -> Value {
+    let mut entry = serde_json::to_value(tool).unwrap_or(Value::Null);
+    if tool.description.chars().count() > max_description_len {
+        let cut: String = tool.description.chars().take(max_description_len).collect();
+        entry["description"] = json!(format!("{}…", cut.trim_end()));
+    }
+    strip_schemas(&mut entry, annotation);
+    entry
+}
+
+fn strip_schemas(entry: &mut Value, drop: fn(&str) -> bool) {
+    for key in ["inputSchema", "outputSchema"] {
+        if let Some(schema) = entry.get_mut(key) {
+            strip_keywords(schema, drop);
+        }
+    }
+}
+
+fn strip_keywords(schema: &mut Value, drop: fn(&str) -> bool) {
+    match schema {
+        Value::Object(map) => {
+            map.retain(|key, _| !drop(key));
+            for (key, value) in map.iter_mut() {
+                match (key.as_str(), value) {
+                    // Literal values, not subschemas.
+                    ("enum" | "const", _) => {}
+                    // Keys here are field names, not keywords.
+                    ("properties" | "patternProperties" | "definitions" | "$defs", Value::Object(props)) => {
+                        props.values_mut().for_each(|v| strip_keywords(v, drop))
+                    }
+                    (_, value) => strip_keywords(value, drop),
+                }
+            }
+        }
+        Value::Array(items) => items.iter_mut().for_each(|v| strip_keywords(v, drop)),
+        _ => {}
+    }
+}
+
+/// Pre-serialize `items` as `{key: [...], "nextCursor": "..."}` pages.
+///
+/// The cursor is the index of the next page; pages follow definition order,
+/// so iteration is stable for the lifetime of a snapshot.  `None` (or a
+/// zero page size) yields one page with no `nextCursor`.
+pub(crate) fn paginate<T: Serialize>(
+    key: &str,
+    items: &[T],
+    page_size: Option<usize>,
+) -> Vec<Arc<RawValue>> {
+    let size = match page_size {
+        Some(n) if n > 0 && items.len() > n => n,
+        _ => return vec![Arc::from(to_raw(&json!({ key: items })))],
+    };
+    let pages = items.len().div_ceil(size);
+    items
+        .chunks(size)
+        .enumerate()
+        .map(|(i, chunk)| {
+            let mut page = json!({ key: chunk });
+            if i + 1 < pages {
+                page["nextCursor"] = json!((i + 1).to_string());
+            }
+            Arc::from(to_raw(&page))
+        })
+        .collect()
+}
+
+/// Look up the page addressed by a client cursor (`None` = first page).
+pub(crate) fn page<'a>(
+    pages: &'a [Arc<RawValue>],
+    cursor: Option<&str>,
+) -> Result<&'a Arc<RawValue>, McpError> {
+    let index = match cursor {
+        None => 0,
+        Some(c) => c
+            .parse::<usize>()
+            .map_err(|_| McpError::Validation(format!("invalid cursor: {}", c)))?,
+    };
+    pages
+        .get(index)
+        .ok_or_else(|| McpError::Validation(format!("invalid cursor: {}", index)))
+}
+
+/// Stable hex digest (64-bit FNV-1a) over the served list payloads.
+///
+/// Identical catalogs always hash the same across processes and releases,
+/// which `std`'s `DefaultHasher` does not guarantee.
+fn content_hash(parts: &[&str]) -> String {
+    let mut hash: u64 = 0xcbf2_9ce4_8422_2325;
+    for part in parts {
+        for byte in part.bytes().chain(std::iter::once(0)) {
+            hash ^= byte as u64;
+            hash = hash.wrapping_mul(0x0000_0100_0000_01b3);
+        }
+    }
+    format!("{:016x}", hash)
+}
+
+/// Check a candidate catalog before it replaces the live one.
+///
+/// Rejects empty or duplicate names, non-object input schemas, and — as a
+/// self-test — tools that have no registered handler, since every call to
+/// such a tool would fail.
+pub(crate) fn validate_candidate(
+    tools: &[Tool],
+    resources: &[Resource],
+    has_handler: impl Fn(&str) -> bool,
+) -> Result<(), McpError> {
+    let mut seen = HashSet::new();
+    for tool in tools {
+        if tool.name.is_empty() {
+            return Err(McpError::Validation("tool with empty name".into()));
+        }
+        if !seen.insert(tool.name.as_str()) {
+            return Err(McpError::Validation(format!(
+                "duplicate tool \"{}\"",
+                tool.name
+            )));
+        }
+        if !tool.input_schema.is_object() {
+            return Err(McpError::Validation(format!(
+                "tool \"{}\": inputSchema must be an object",
+                tool.name
+            )));
+        }
+        // Composite and exec tools run without a registered handler.
+        if tool.steps.is_empty() && tool.exec.is_none() && !has_handler(&tool.name) {
+            return Err(McpError::Validation(format!(
+                "tool \"{}\" has no registered handler",
+                tool.name
+            )));
+        }
+    }
+
+    let mut seen = HashSet::new();
+    for resource in resources {
+        if resource.name.is_empty() {
+            return Err(McpError::Validation("resource with empty name".into()));
+        }
+        if !seen.insert(resource.name.as_str()) {
+            return Err(McpError::Validation(format!(
+                "duplicate resource \"{}\"",
+                resource.name
+            )));
+        }
+    }
+
+    Ok(())
+}
+
+/// Serialize a Value to a pre-validated `Box<RawValue>`.
+pub(crate) fn to_raw(value: &Value) -> Box<RawValue> {
+    RawValue::from_string(serde_json::to_string(value).unwrap()).unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::loader::{parse_resources, parse_tools};
+
+    fn tools(json: &str) -> Vec<Tool> {
+        parse_tools(json.as_bytes()).unwrap()
+    }
+
+    #[test]
+    fn test_catalog_new_indexes_and_serializes() {
+        let catalog = Catalog::new(
+            tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}}]"#),
+            parse_resources(br#"[{"name":"r","description":"r","uri":"file:///r","mimeType":"text/plain"}]"#).unwrap(),
+        );
+        assert!(catalog.tools.contains_key("a"));
+        assert!(catalog.resources.contains_key("r"));
+        assert!(catalog.tools_list_result.get().contains("\"name\":\"a\""));
+    }
+
+    #[test]
+    fn test_definitions_keep_served_order() {
+        let catalog = Catalog::new(
+            tools(r#"[{"name":"b","description":"b","inputSchema":{"type":"object"}},
+                      {"name":"a","description":"a","inputSchema":{"type":"object"}}]"#),
+            vec![],
+        );
+        let (tools, _) = catalog.definitions();
+        let names: Vec<&str> = tools.iter().map(|t| t.name.as_str()).collect();
+        assert_eq!(names, vec!["b", "a"]);
+    }
+
+    #[test]
+    fn test_published_resources_are_listed_but_not_definitions() {
+        let catalog = Catalog::new(
+            tools(r#"[{"name":"channels-list","description":"c","inputSchema":{"type":"object"},
+                "publishAs":{"name":"channels","description":"d","uri":"resource://channels/catalog.json","mimeType":"application/json"}}]"#),
+            vec![],
+        );
+        assert_eq!(catalog.resources["channels"].uri, "resource://channels/catalog.json");
+        assert!(catalog.resources_pages[0].get().contains("resource://channels/catalog.json"));
+        let (_, resources) = catalog.definitions();
+        assert!(resources.is_empty());
+    }
+
+    #[test]
+    fn test_compact_tool() {
+        let tool = tools(r#"[{"name":"t","description":"Looks up the weather for a city","inputSchema":{
+            "type":"object","title":"Args","description":"verbose",
+            "properties":{"description":{"type":"string","description":"free text","examples":["x"]},
+                          "unit":{"enum":[{"title":"kept"}],"default":"c"}},
+            "required":["description"]}}]"#)
+        .remove(0);
+        let entry = compact_tool(&tool, 12);
+        assert_eq!(entry["description"], "Looks up the…");
+        assert_eq!(
+            entry["inputSchema"],
+            json!({"type":"object","properties":{"description":{"type":"string"},"unit":{"enum":[{"title":"kept"}]}},"required":["description"]})
+        );
+        assert_eq!(compact_tool(&tool, 100)["description"], tool.description);
+    }
+
+    #[test]
+    fn test_minimized_schemas_keep_validation() {
+        let tool = r#"[{"name":"t","description":"d","inputSchema":{"type":"object","$comment":"authoring note",
+            "x-owner":"team-geo","properties":{"city":{"type":"string","description":"City","examples":["Paris"]}},
+            "required":["city"]}}]"#;
+        let options = ListOptions { minimize_schemas: true, ..Default::default() };
+        let catalog = Catalog::with_options(tools(tool), vec![], options);
+        let listed: Value = serde_json::from_str(catalog.tools_list_result.get()).unwrap();
+        assert_eq!(
+            listed["tools"][0]["inputSchema"],
+            json!({"type":"object","properties":{"city":{"type":"string","description":"City"}},"required":["city"]})
+        );
+        assert_eq!(catalog.list_entry(&catalog.tools["t"]), listed["tools"][0]);
+        // The definition itself is untouched and still validates.
+        assert!(catalog.tools["t"].input_schema.get("$comment").is_some());
+        assert!(catalog.tools["t"].validate_arguments(&json!({})).is_err());
+    }
+
+    #[test]
+    fn test_paginate() {
+        let items: Vec<u32> = (0..5).collect();
+        let pages = paginate("items", &items, Some(2));
+        assert_eq!(pages.len(), 3);
+        assert_eq!(pages[0].get(), r#"{"items":[0,1],"nextCursor":"1"}"#);
+        assert_eq!(pages[2].get(), r#"{"items":[4]}"#);
+
+        assert_eq!(paginate("items", &items, None).len(), 1);
+        assert_eq!(paginate("items", &items, Some(5))[0].get(), r#"{"items":[0,1,2,3,4]}"#);
+
+        assert_eq!(page(&pages, Some("2")).unwrap().get(), r#"{"items":[4]}"#);
+        assert!(page(&pages, Some("3")).is_err());
+        assert!(page(&pages, Some("x")).is_err());
+    }
+
+    #[test]
+    fn test_catalog_hash_is_content_based() {
+        let a = Catalog::new(tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}}]"#), vec![]);
+        let b = Catalog::new(tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}}]"#), vec![]);
+        let c = Catalog::new(tools(r#"[{"name":"a","description":"b","inputSchema":{"type":"object"}}]"#), vec![]);
+        assert_eq!(a.hash, b.hash);
+        assert_ne!(a.hash, c.hash);
+        assert_eq!(a.hash.len(), 16);
+    }
+
+    #[test]
+    fn test_validate_candidate_duplicate_tool() {
+        let t = tools(
+            r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}},
+                {"name":"a","description":"a","inputSchema":{"type":"object"}}]"#,
+        );
+        let err = validate_candidate(&t, &[], |_| true).unwrap_err();
+        assert!(err.to_string().contains("duplicate tool"));
+    }
+
+    #[test]
+    fn test_validate_candidate_missing_handler() {
+        let t = tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}}]"#);
+        let err = validate_candidate(&t, &[], |_| false).unwrap_err();
+        assert!(err.to_string().contains("no registered handler"));
+    }
+
+    #[test]
+    fn test_validate_candidate_bad_schema() {
+        let t = tools(r#"[{"name":"a","description":"a"}]"#);
+        assert!(validate_candidate(&t, &[], |_| true).is_err());
+    }
+}
@@ -0,0 +1,113 @@
+use std::fmt;
+use std::sync::Mutex;
+use std::time::{Duration, Instant, SystemTime};
+
+/// Source of time for everything the server measures or expires: tool
+/// latency, tool timeouts and exec deadlines, the retry-dedup window and
+/// maintenance retry hints.
+///
+/// The default is [`SystemClock`]; tests inject a [`ManualClock`] to step
+/// time forward without sleeping.
+pub trait Clock: Send + Sync {
+    /// Monotonic time, for measuring intervals.
+    fn now(&self) -> Instant;
+    /// Wall-clock time, for deadlines shared with clients.
+    fn system_time(&self) -> SystemTime;
+    /// Call `wake` whenever the clock jumps, so whoever sleeps until a
+    /// deadline can look again.  Clocks that move on their own need not.
+    fn on_advance(&self, wake: Box<dyn Fn() + Send + Sync>) {
+        let _ = wake;
+    }
+}
+
+/// The real clock.
+#[derive(Debug, Default, Clone, Copy)]
+pub struct SystemClock;
+
+impl Clock for SystemClock {
+    fn now(&self) -> Instant {
+        Instant::now()
+    }
+
+    fn system_time(&self) -> SystemTime {
+        SystemTime::now()
+    }
+}
+
+/// A clock that only moves when told to.
+pub struct ManualClock {
+    start: Instant,
+    start_system: SystemTime,
+    offset: Mutex<Duration>,
+    listeners: Mutex<Vec<Box<dyn Fn() + Send + Sync>>>,
+}
+
+impl fmt::Debug for ManualClock {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("ManualClock")
+            .field("start", &self.start)
+            .field("start_system", &self.start_system)
+            .field("offset", &self.offset())
+            .finish_non_exhaustive()
+    }
+}
+
+impl ManualClock {
+    /// A clock frozen at the current time.
+    pub fn new() -> Self {
+        ManualClock {
+            start: Instant::now(),
+            start_system: SystemTime::now(),
+            offset: Mutex::new(Duration::ZERO),
+            listeners: Mutex::new(Vec::new()),
+        }
+    }
+
+    /// Move the clock forward by `d`, firing any timeouts that come due.
+    pub fn advance(&self, d: Duration) {
+        *self.offset.lock().unwrap_or_else(|e| e.into_inner()) += d;
+        for wake in self.listeners.lock().unwrap_or_else(|e| e.into_inner()).iter() {
+            wake();
+        }
+    }
+
+    fn offset(&self) -> Duration {
+        *self.offset.lock().unwrap_or_else(|e| e.into_inner())
+    }
+}
+
+impl Default for ManualClock {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Clock for ManualClock {
+    fn now(&self) -> Instant {
+        self.start + self.offset()
+    }
+
+    fn system_time(&self) -> SystemTime {
+        self.start_system + self.offset()
+    }
+
+    fn on_advance(&self, wake: Box<dyn Fn() + Send + Sync>) {
+        self.listeners.lock().unwrap_or_else(|e| e.into_inner()).push(wake);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_manual_clock_advances_both_times() {
+        let clock = ManualClock::new();
+        let (t0, s0) = (clock.now(), clock.system_time());
+        assert_eq!(clock.now(), t0);
+
+        clock.advance(Duration::from_secs(90));
+        assert_eq!(clock.now() - t0, Duration::from_secs(90));
+        assert_eq!(clock.system_time().duration_since(s0).unwrap(), Duration::from_secs(90));
+    }
+}
@@ -0,0 +1,162 @@
+use std::collections::HashSet;
+use std::fmt;
+
+use serde_json::Value;
+
+use crate::types::Tool;
+
+/// A change to a tool's input schema that can break existing callers.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum CompatIssue {
+    /// A field that used to be optional (or absent) is now required.
+    NewlyRequired { field: String },
+    /// A property was removed from the schema.
+    PropertyRemoved { field: String },
+    /// A property's `type` changed.
+    TypeChanged { field: String, old: String, new: String },
+    /// Values were removed from a property's `enum`.
+    EnumNarrowed { field: String, removed: Vec<String> },
+}
+
+impl fmt::Display for CompatIssue {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            CompatIssue::NewlyRequired { field } => {
+                write!(f, "field \"{}\" is now required", field)
+            }
+            CompatIssue::PropertyRemoved { field } => {
+                write!(f, "property \"{}\" was removed", field)
+            }
+            CompatIssue::TypeChanged { field, old, new } => {
+                write!(f, "property \"{}\" changed type from {} to {}", field, old, new)
+            }
+            CompatIssue::EnumNarrowed { field, removed } => {
+                write!(
+                    f,
+                    "property \"{}\" no longer accepts {}",
+                    field,
+                    removed.join(", ")
+                )
+            }
+        }
+    }
+}
+
+/// Report the breaking changes between two versions of the same tool.
+///
+/// An empty result means every call that was valid against `old` is still
+/// accepted by `new` (within the schema features this crate understands:
+/// `required`, `properties`, property `type` and `enum`).
+pub fn check_backward_compatible(old: &Tool, new: &Tool) -> Vec<CompatIssue> {
+    let mut issues = Vec::new();
+
+    let old_required: HashSet<&str> = old.schema_meta.required.iter().map(String::as_str).collect();
+    for field in &new.schema_meta.required {
+        if !old_required.contains(field.as_str()) {
+            issues.push(CompatIssue::NewlyRequired {
+                field: field.clone(),
+            });
+        }
+    }
+
+    let empty = serde_json::Map::new();
+    let old_props = properties(&old.input_schema).unwrap_or(&empty);
+    let new_props = properties(&new.input_schema).unwrap_or(&empty);
+
+    for (field, old_prop) in old_props {
+        let Some(new_prop) = new_props.get(field) else {
+            issues.push(CompatIssue::PropertyRemoved {
+                field: field.clone(),
+            });
+            continue;
+        };
+
+        if let (Some(old_type), Some(new_type)) = (old_prop.get("type"), new_prop.get("type")) {
+            if old_type != new_type {
+                issues.push(CompatIssue::TypeChanged {
+                    field: field.clone(),
+                    old: old_type.to_string(),
+                    new: new_type.to_string(),
+                });
+            }
+        }
+
+        // An enum added where there was none narrows the accepted values too,
+        // but we cannot name what was removed; only report concrete removals.
+        if let (Some(old_enum), Some(new_enum)) = (
+            old_prop.get("enum").and_then(|v| v.as_array()),
+            new_prop.get("enum").and_then(|v| v.as_array()),
+        ) {
+            let removed: Vec<String> = old_enum
+                .iter()
+                .filter(|v| !new_enum.contains(v))
+                .map(|v| v.to_string())
+                .collect();
+            if !removed.is_empty() {
+                issues.push(CompatIssue::EnumNarrowed {
+                    field: field.clone(),
+                    removed,
+                });
+            }
+        }
+    }
+
+    issues
+}
+
+fn properties(schema: &Value) -> Option<&serde_json::Map<String, Value>> {
+    schema.get("properties").and_then(|v| v.as_object())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::loader::parse_tools;
+
+    fn tool(schema_json: &str) -> Tool {
+        let json = format!(
+            r#"[{{"name":"t","description":"t","inputSchema":{}}}]"#,
+            schema_json
+        );
+        parse_tools(json.as_bytes()).unwrap().remove(0)
+    }
+
+    #[test]
+    fn test_compatible_additions() {
+        let old = tool(r#"{"type":"object","properties":{"a":{"type":"string"}}}"#);
+        let new = tool(r#"{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"number"}}}"#);
+        assert!(check_backward_compatible(&old, &new).is_empty());
+    }
+
+    #[test]
+    fn test_newly_required() {
+        let old = tool(r#"{"type":"object","properties":{"a":{}}}"#);
+        let new = tool(r#"{"type":"object","properties":{"a":{}},"required":["a"]}"#);
+        let issues = check_backward_compatible(&old, &new);
+        assert_eq!(issues, vec![CompatIssue::NewlyRequired { field: "a".into() }]);
+    }
+
+    #[test]
+    fn test_property_removed_and_type_changed() {
+        let old = tool(r#"{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"string"}}}"#);
+        let new = tool(r#"{"type":"object","properties":{"a":{"type":"number"}}}"#);
+        let issues = check_backward_compatible(&old, &new);
+        assert_eq!(issues.len(), 2);
+        assert!(issues.contains(&CompatIssue::PropertyRemoved { field: "b".into() }));
+        assert!(issues[0].to_string().contains("changed type") || issues[1].to_string().contains("changed type"));
+    }
+
+    #[test]
+    fn test_enum_narrowed() {
+        let old = tool(r#"{"type":"object","properties":{"style":{"enum":["formal","casual"]}}}"#);
+        let new = tool(r#"{"type":"object","properties":{"style":{"enum":["formal"]}}}"#);
+        let issues = check_backward_compatible(&old, &new);
+        assert_eq!(
+            issues,
+            vec![CompatIssue::EnumNarrowed {
+                field: "style".into(),
+                removed: vec!["\"casual\"".into()],
+            }]
+        );
+    }
+}
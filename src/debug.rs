@@ -0,0 +1,16 @@
+use std::sync::Arc;
+use std::time::Duration;
+
+/// A captured request/response pair, already passed through the server's
+/// output filter so secrets and PII are redacted.
+#[derive(Debug, Clone)]
+pub struct DebugCapture {
+    pub method: String,
+    /// The JSON-RPC request as received.
+    pub request: String,
+    /// The JSON-RPC response as it will be sent; empty for notifications.
+    pub response: String,
+    pub elapsed: Duration,
+}
+
+pub(crate) type DebugSinkFn = Arc<dyn Fn(&DebugCapture) + Send + Sync>;
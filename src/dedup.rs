@@ -0,0 +1,224 @@
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use std::task::{Poll, Waker};
+use std::time::{Duration, Instant};
+
+use serde_json::Value;
+
+use crate::clock::Clock;
+use crate::types::McpResponse;
+
+/// Upper bound on remembered responses; the oldest entries are evicted
+/// first when a burst outgrows it within one window.
+const MAX_ENTRIES: usize = 10_000;
+
+/// (session, request ID, call) — the call is the tool name and arguments,
+/// so a reused ID for a different call is not mistaken for a retry.
+type Key = (String, String, String);
+
+/// Short-lived memory of `tools/call` responses, so a client retrying the
+/// same request gets the original response instead of running a mutating
+/// tool twice.  A retry that arrives while the first call is still running
+/// waits for its response.
+pub(crate) struct DedupCache {
+    window: Duration,
+    clock: Arc<dyn Clock>,
+    state: Mutex<State>,
+}
+
+#[derive(Default)]
+struct State {
+    entries: HashMap<Key, Entry>,
+    /// Finished keys in the order they were answered, so expiry and the
+    /// size cap only look at the oldest entries.
+    order: VecDeque<(Instant, Key)>,
+}
+
+enum Entry {
+    Running(Arc<Pending>),
+    Done(Instant, McpResponse),
+}
+
+/// What to do with a `tools/call`, from [`DedupCache::claim`].
+pub(crate) enum Claim<'a> {
+    /// A fresh response to the same call.
+    Replay(McpResponse),
+    /// The same call is still running; wait for its response.
+    Wait(Arc<Pending>),
+    /// Run the call and hand the response to the ticket.
+    Run(Ticket<'a>),
+}
+
+impl DedupCache {
+    pub fn new(window: Duration, clock: Arc<dyn Clock>) -> Self {
+        DedupCache {
+            window,
+            clock,
+            state: Mutex::new(State::default()),
+        }
+    }
+
+    pub fn claim(&self, session: &str, id: &Value, call: &Value) -> Claim<'_> {
+        // `1` and `"1"` are different IDs; the JSON rendering keeps them apart.
+        let key = (session.to_string(), id.to_string(), call.to_string());
+        let mut state = self.lock();
+        let now = self.clock.now();
+        match state.entries.get(&key) {
+            Some(Entry::Running(pending)) => return Claim::Wait(Arc::clone(pending)),
+            Some(Entry::Done(at, resp)) if now - *at < self.window => return Claim::Replay(resp.clone()),
+            _ => {}
+        }
+        let pending = Arc::new(Pending::default());
+        state.entries.insert(key.clone(), Entry::Running(Arc::clone(&pending)));
+        Claim::Run(Ticket {
+            cache: self,
+            key: Some(key),
+            pending,
+        })
+    }
+
+    /// Drop expired entries and, past the cap, the oldest ones.
+    fn prune(&self, state: &mut State, now: Instant) {
+        while let Some((at, _)) = state.order.front() {
+            if now - *at < self.window && state.order.len() <= MAX_ENTRIES {
+                break;
+            }
+            let (at, key) = state.order.pop_front().unwrap();
+            // The key may have been answered again since; keep the newer one.
+            if matches!(state.entries.get(&key), Some(Entry::Done(done, _)) if *done == at) {
+                state.entries.remove(&key);
+            }
+        }
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, State> {
+        self.state.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    #[cfg(test)]
+    pub fn get(&self, session: &str, id: &Value, call: &Value) -> Option<McpResponse> {
+        let key = (session.to_string(), id.to_string(), call.to_string());
+        let now = self.clock.now();
+        match self.lock().entries.get(&key) {
+            Some(Entry::Done(at, resp)) if now - *at < self.window => Some(resp.clone()),
+            _ => None,
+        }
+    }
+}
+
+/// The right to run a call.  Dropping it unfinished (the request was
+/// cancelled) forgets the call, and waiting retries run it themselves.
+pub(crate) struct Ticket<'a> {
+    cache: &'a DedupCache,
+    key: Option<Key>,
+    pending: Arc<Pending>,
+}
+
+impl Ticket<'_> {
+    pub fn finish(mut self, resp: McpResponse) {
+        let key = self.key.take().unwrap();
+        let cache = self.cache;
+        let now = cache.clock.now();
+        let mut state = cache.lock();
+        state.entries.insert(key.clone(), Entry::Done(now, resp.clone()));
+        state.order.push_back((now, key));
+        cache.prune(&mut state, now);
+        drop(state);
+        self.pending.resolve(Some(resp));
+    }
+}
+
+impl Drop for Ticket<'_> {
+    fn drop(&mut self) {
+        if let Some(key) = self.key.take() {
+            self.cache.lock().entries.remove(&key);
+            self.pending.resolve(None);
+        }
+    }
+}
+
+/// The response of a running call, for retries that arrive meanwhile.
+#[derive(Default)]
+pub(crate) struct Pending {
+    slot: Mutex<(Option<Option<McpResponse>>, Vec<Waker>)>,
+}
+
+impl Pending {
+    fn resolve(&self, resp: Option<McpResponse>) {
+        let mut slot = self.slot.lock().unwrap_or_else(|e| e.into_inner());
+        slot.0 = Some(resp);
+        slot.1.drain(..).for_each(Waker::wake);
+    }
+
+    /// The first call's response, or `None` if it never finished.
+    pub async fn wait(&self) -> Option<McpResponse> {
+        std::future::poll_fn(|cx| {
+            let mut slot = self.slot.lock().unwrap_or_else(|e| e.into_inner());
+            match &slot.0 {
+                Some(resp) => Poll::Ready(resp.clone()),
+                None => {
+                    slot.1.push(cx.waker().clone());
+                    Poll::Pending
+                }
+            }
+        })
+        .await
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::clock::ManualClock;
+    use serde_json::json;
+
+    fn run(cache: &DedupCache, session: &str, id: Value, call: &Value, result: &str) {
+        match cache.claim(session, &id, call) {
+            Claim::Run(ticket) => ticket.finish(McpResponse::ok(Some(id), json!(result))),
+            _ => panic!("expected to run"),
+        }
+    }
+
+    #[test]
+    fn test_hit_within_window_only() {
+        let clock = Arc::new(ManualClock::new());
+        let cache = DedupCache::new(Duration::from_millis(20), clock.clone());
+        let call = json!(["echo", {}]);
+        run(&cache, "s", json!(1), &call, "first");
+
+        let Claim::Replay(hit) = cache.claim("s", &json!(1), &call) else { panic!() };
+        assert_eq!(hit.into_json_rpc().result, Some(json!("first")));
+        assert!(cache.get("other", &json!(1), &call).is_none());
+        assert!(cache.get("s", &json!("1"), &call).is_none());
+        assert!(cache.get("s", &json!(1), &json!(["echo", {"x": 1}])).is_none());
+
+        clock.advance(Duration::from_millis(19));
+        assert!(cache.get("s", &json!(1), &call).is_some());
+        clock.advance(Duration::from_millis(1));
+        assert!(cache.get("s", &json!(1), &call).is_none());
+        // Expired entries are pruned as new ones are answered.
+        run(&cache, "s", json!(2), &call, "second");
+        assert_eq!(cache.lock().entries.len(), 1);
+    }
+
+    #[tokio::test]
+    async fn test_retry_waits_for_running_call() {
+        let cache = DedupCache::new(Duration::from_secs(60), Arc::new(ManualClock::new()));
+        let call = json!(["echo", {}]);
+        let Claim::Run(ticket) = cache.claim("s", &json!(1), &call) else { panic!() };
+        let Claim::Wait(pending) = cache.claim("s", &json!(1), &call) else { panic!() };
+
+        let (resp, ()) = tokio::join!(pending.wait(), async {
+            tokio::task::yield_now().await;
+            ticket.finish(McpResponse::ok(Some(json!(1)), json!("once")));
+        });
+        assert_eq!(resp.unwrap().into_json_rpc().result, Some(json!("once")));
+
+        // A cancelled first call releases its waiters without a response.
+        let Claim::Run(ticket) = cache.claim("s", &json!(2), &call) else { panic!() };
+        let Claim::Wait(pending) = cache.claim("s", &json!(2), &call) else { panic!() };
+        drop(ticket);
+        assert!(pending.wait().await.is_none());
+        assert!(matches!(cache.claim("s", &json!(2), &call), Claim::Run(_)));
+    }
+}
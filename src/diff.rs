@@ -0,0 +1,201 @@
+use std::collections::BTreeMap;
+
+use serde::Serialize;
+
+use crate::compat::check_backward_compatible;
+use crate::types::{Resource, Tool};
+
+/// Differences between two tool/resource catalogs.
+///
+/// Names in every list are sorted, so the output is deterministic and can be
+/// rendered directly into release notes.
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CatalogDiff {
+    pub tools_added: Vec<String>,
+    pub tools_removed: Vec<String>,
+    pub tools_changed: Vec<ToolChange>,
+    pub resources_added: Vec<String>,
+    pub resources_removed: Vec<String>,
+    pub resources_changed: Vec<String>,
+}
+
+/// A tool present in both catalogs whose definition changed.
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ToolChange {
+    pub name: String,
+    pub description_changed: bool,
+    pub schema_changed: bool,
+    /// Human-readable reasons this change can break existing callers.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub breaking: Vec<String>,
+}
+
+impl CatalogDiff {
+    /// True when the two catalogs are identical.
+    pub fn is_empty(&self) -> bool {
+        self.tools_added.is_empty()
+            && self.tools_removed.is_empty()
+            && self.tools_changed.is_empty()
+            && self.resources_added.is_empty()
+            && self.resources_removed.is_empty()
+            && self.resources_changed.is_empty()
+    }
+
+    /// True when the change can break existing callers: a tool was removed
+    /// or a changed tool carries breaking reasons.
+    pub fn is_breaking(&self) -> bool {
+        !self.tools_removed.is_empty() || self.tools_changed.iter().any(|c| !c.breaking.is_empty())
+    }
+}
+
+/// Diff two catalogs given as slices of definitions.
+pub fn diff_catalogs(
+    old_tools: &[Tool],
+    old_resources: &[Resource],
+    new_tools: &[Tool],
+    new_resources: &[Resource],
+) -> CatalogDiff {
+    diff_iter(
+        old_tools.iter(),
+        old_resources.iter(),
+        new_tools.iter(),
+        new_resources.iter(),
+    )
+}
+
+/// Shared implementation used by [`diff_catalogs`] and the server's
+/// snapshot history (which stores definitions in maps rather than slices).
+pub(crate) fn diff_iter<'a>(
+    old_tools: impl Iterator<Item = &'a Tool>,
+    old_resources: impl Iterator<Item = &'a Resource>,
+    new_tools: impl Iterator<Item = &'a Tool>,
+    new_resources: impl Iterator<Item = &'a Resource>,
+) -> CatalogDiff {
+    let old_tools: BTreeMap<&str, &Tool> = old_tools.map(|t| (t.name.as_str(), t)).collect();
+    let new_tools: BTreeMap<&str, &Tool> = new_tools.map(|t| (t.name.as_str(), t)).collect();
+    let old_resources: BTreeMap<&str, &Resource> =
+        old_resources.map(|r| (r.name.as_str(), r)).collect();
+    let new_resources: BTreeMap<&str, &Resource> =
+        new_resources.map(|r| (r.name.as_str(), r)).collect();
+
+    let mut diff = CatalogDiff::default();
+
+    for (name, new) in &new_tools {
+        match old_tools.get(name) {
+            None => diff.tools_added.push(name.to_string()),
+            Some(old) => {
+                if let Some(change) = diff_tool(old, new) {
+                    diff.tools_changed.push(change);
+                }
+            }
+        }
+    }
+    diff.tools_removed = old_tools
+        .keys()
+        .filter(|name| !new_tools.contains_key(*name))
+        .map(|name| name.to_string())
+        .collect();
+
+    for (name, new) in &new_resources {
+        match old_resources.get(name) {
+            None => diff.resources_added.push(name.to_string()),
+            Some(old) => {
+                if old.uri != new.uri
+                    || old.mime_type != new.mime_type
+                    || old.description != new.description
+                {
+                    diff.resources_changed.push(name.to_string());
+                }
+            }
+        }
+    }
+    diff.resources_removed = old_resources
+        .keys()
+        .filter(|name| !new_resources.contains_key(*name))
+        .map(|name| name.to_string())
+        .collect();
+
+    diff
+}
+
+/// Compare two versions of the same tool; `None` when nothing changed.
+fn diff_tool(old: &Tool, new: &Tool) -> Option<ToolChange> {
+    let description_changed = old.description != new.description;
+    let schema_changed = old.input_schema != new.input_schema;
+    if !description_changed && !schema_changed {
+        return None;
+    }
+
+    let breaking = if schema_changed {
+        check_backward_compatible(old, new)
+            .iter()
+            .map(ToString::to_string)
+            .collect()
+    } else {
+        Vec::new()
+    };
+
+    Some(ToolChange {
+        name: new.name.clone(),
+        description_changed,
+        schema_changed,
+        breaking,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::loader::{parse_resources, parse_tools};
+
+    fn tools(json: &str) -> Vec<Tool> {
+        parse_tools(json.as_bytes()).unwrap()
+    }
+
+    #[test]
+    fn test_diff_identical_is_empty() {
+        let t = tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}}]"#);
+        let diff = diff_catalogs(&t, &[], &t, &[]);
+        assert!(diff.is_empty());
+        assert!(!diff.is_breaking());
+    }
+
+    #[test]
+    fn test_diff_added_removed_changed() {
+        let old = tools(
+            r#"[{"name":"a","description":"a","inputSchema":{"type":"object"}},
+                {"name":"b","description":"b","inputSchema":{"type":"object"}}]"#,
+        );
+        let new = tools(
+            r#"[{"name":"a","description":"a v2","inputSchema":{"type":"object"}},
+                {"name":"c","description":"c","inputSchema":{"type":"object"}}]"#,
+        );
+        let diff = diff_catalogs(&old, &[], &new, &[]);
+        assert_eq!(diff.tools_added, vec!["c"]);
+        assert_eq!(diff.tools_removed, vec!["b"]);
+        assert_eq!(diff.tools_changed.len(), 1);
+        assert!(diff.tools_changed[0].description_changed);
+        assert!(!diff.tools_changed[0].schema_changed);
+        assert!(diff.is_breaking());
+    }
+
+    #[test]
+    fn test_diff_new_required_field_is_breaking() {
+        let old = tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object","required":["x"]}}]"#);
+        let new = tools(r#"[{"name":"a","description":"a","inputSchema":{"type":"object","required":["x","y"]}}]"#);
+        let diff = diff_catalogs(&old, &[], &new, &[]);
+        assert_eq!(diff.tools_changed[0].breaking, vec!["field \"y\" is now required"]);
+        assert!(diff.is_breaking());
+    }
+
+    #[test]
+    fn test_diff_resources() {
+        let old = parse_resources(br#"[{"name":"r","description":"r","uri":"file:///a","mimeType":"text/csv"}]"#).unwrap();
+        let new = parse_resources(br#"[{"name":"r","description":"r","uri":"file:///b","mimeType":"text/csv"}]"#).unwrap();
+        let diff = diff_catalogs(&[], &old, &[], &new);
+        assert_eq!(diff.resources_changed, vec!["r"]);
+        assert!(!diff.is_breaking());
+    }
+}
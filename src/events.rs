@@ -0,0 +1,91 @@
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, RwLock};
+use std::time::Duration;
+
+use crate::diff::CatalogDiff;
+use crate::types::ClientInfo;
+
+/// Something that happened inside the server, delivered to every
+/// subscriber added with [`Server::subscribe`](crate::Server::subscribe).
+///
+/// Audit trails, usage billing and cache invalidation can be built as
+/// subscribers instead of changes to the dispatcher.  New variants may be
+/// added, so match with a wildcard arm.
+#[derive(Debug, Clone)]
+#[non_exhaustive]
+pub enum Event {
+    /// A `tools/call` finished.  `is_error` matches what metrics see.
+    ToolCalled {
+        tool: String,
+        session: Option<String>,
+        elapsed: Duration,
+        is_error: bool,
+    },
+    /// A `tools/call` was abandoned before its handler finished, because
+    /// the client disconnected or sent `notifications/cancelled`.
+    /// `detached` is true when the handler keeps running to completion
+    /// (see [`complete_on_disconnect`](crate::ServerBuilder::complete_on_disconnect)).
+    ToolCancelled {
+        tool: String,
+        session: Option<String>,
+        elapsed: Duration,
+        detached: bool,
+    },
+    /// A session sent its first `initialize`.
+    SessionCreated { session: String, client: ClientInfo },
+    /// A new catalog went live (reload, add/remove, or patch).
+    ConfigReloaded { hash: String, diff: CatalogDiff },
+    /// A `resources/read` finished.  `uri` is as requested: the resource's
+    /// `name` when it was read by name.
+    ResourceRead {
+        uri: String,
+        session: Option<String>,
+        is_error: bool,
+    },
+}
+
+/// Identifies a subscription for [`Server::unsubscribe`](crate::Server::unsubscribe).
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub struct SubscriptionId(u64);
+
+type Subscriber = Arc<dyn Fn(&Event) + Send + Sync>;
+
+/// Subscribers in the order they were added.  Publishing runs them inline
+/// on the request's task, so they should be quick; hand slow work to a
+/// channel.
+#[derive(Default)]
+pub(crate) struct EventBus {
+    subscribers: RwLock<Vec<(SubscriptionId, Subscriber)>>,
+    next_id: AtomicU64,
+}
+
+impl EventBus {
+    pub fn subscribe(&self, f: Subscriber) -> SubscriptionId {
+        let id = SubscriptionId(self.next_id.fetch_add(1, Ordering::Relaxed));
+        self.subscribers
+            .write()
+            .unwrap_or_else(|e| e.into_inner())
+            .push((id, f));
+        id
+    }
+
+    pub fn unsubscribe(&self, id: SubscriptionId) -> bool {
+        let mut subscribers = self.subscribers.write().unwrap_or_else(|e| e.into_inner());
+        let before = subscribers.len();
+        subscribers.retain(|(sub, _)| *sub != id);
+        subscribers.len() != before
+    }
+
+    /// True when nobody is listening, so callers can skip building events.
+    pub fn is_empty(&self) -> bool {
+        self.subscribers.read().unwrap_or_else(|e| e.into_inner()).is_empty()
+    }
+
+    pub fn publish(&self, event: Event) {
+        // Clone the list so a subscriber may (un)subscribe without deadlock.
+        let subscribers = self.subscribers.read().unwrap_or_else(|e| e.into_inner()).clone();
+        for (_, f) in &subscribers {
+            f(&event);
+        }
+    }
+}
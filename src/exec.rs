@@ -0,0 +1,338 @@
+use std::collections::BTreeMap;
+use std::io::{Read, Write};
+use std::process::{Child, ChildStdout, Command, Stdio};
+use std::sync::{Arc, Mutex, TryLockError};
+use std::thread::JoinHandle;
+use std::time::Duration;
+
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+
+use crate::cancel;
+use crate::timer::Timer;
+use crate::types::{error_result, text_result, ToolResult};
+
+/// Default wall-clock limit for one command.
+pub const DEFAULT_EXEC_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// Default cap on captured stdout, in bytes.
+pub const DEFAULT_EXEC_OUTPUT: usize = 1 << 20;
+
+/// How much stderr to quote in the error of a failed command.
+const STDERR_QUOTE: usize = 1024;
+
+/// A tool backed by a local command (`exec` in config) instead of a
+/// registered handler.
+///
+/// The command runs without a shell and with an empty environment.  Its
+/// argv is `command`, then `args`, then one entry per `flags` item whose
+/// argument is present (`true` adds just the flag, `false` and `null` skip
+/// it, anything else adds the flag and the value), then the values of
+/// `positional`, after a `--` if `endOfOptions` is set.  `env` sets
+/// variables from arguments and `stdin` names an argument written to
+/// standard input.  Stdout becomes the text result.
+///
+/// Flag and positional values starting with `-` are rejected, so a caller
+/// cannot slip extra options to the command; set `allowDashValues` for
+/// commands that need such values (e.g. negative numbers).
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase", deny_unknown_fields)]
+pub struct ExecSpec {
+    /// Absolute path; must be allowed with
+    /// [`allow_exec`](crate::ServerBuilder::allow_exec).
+    pub command: String,
+    #[serde(default)]
+    pub args: Vec<String>,
+    /// Flag → argument name.
+    #[serde(default)]
+    pub flags: BTreeMap<String, String>,
+    /// Argument names appended in order.
+    #[serde(default)]
+    pub positional: Vec<String>,
+    /// Environment variable → argument name.
+    #[serde(default)]
+    pub env: BTreeMap<String, String>,
+    #[serde(default)]
+    pub stdin: Option<String>,
+    #[serde(default)]
+    pub timeout_ms: Option<u64>,
+    #[serde(default)]
+    pub max_output_bytes: Option<usize>,
+    #[serde(default)]
+    pub allow_dash_values: bool,
+    /// Put `--` before the positional values, for commands that read it as
+    /// the end of their options.
+    #[serde(default)]
+    pub end_of_options: bool,
+}
+
+impl ExecSpec {
+    fn timeout(&self) -> Duration {
+        self.timeout_ms.map(Duration::from_millis).unwrap_or(DEFAULT_EXEC_TIMEOUT)
+    }
+
+    fn max_output(&self) -> usize {
+        self.max_output_bytes.unwrap_or(DEFAULT_EXEC_OUTPUT)
+    }
+
+    /// The argv after `command`, built from the call's arguments.
+    fn argv(&self, args: &Value) -> Result<Vec<String>, String> {
+        let value = |name: &str, v: &Value| {
+            let v = plain(v);
+            match v.starts_with('-') && !self.allow_dash_values {
+                true => Err(format!("argument {} must not start with '-'", name)),
+                false => Ok(v),
+            }
+        };
+        let mut argv = self.args.clone();
+        for (flag, name) in &self.flags {
+            match args.get(name) {
+                None | Some(Value::Null) | Some(Value::Bool(false)) => {}
+                Some(Value::Bool(true)) => argv.push(flag.clone()),
+                Some(v) => {
+                    argv.push(flag.clone());
+                    argv.push(value(name, v)?);
+                }
+            }
+        }
+        let positional: Vec<(&String, &Value)> = self
+            .positional
+            .iter()
+            .filter_map(|name| Some((name, args.get(name)?)))
+            .collect();
+        if self.end_of_options && !positional.is_empty() {
+            argv.push("--".into());
+        }
+        for (name, v) in positional {
+            argv.push(value(name, v)?);
+        }
+        Ok(argv)
+    }
+}
+
+/// An argument as command-line text: strings verbatim, anything else as
+/// JSON.
+fn plain(v: &Value) -> String {
+    match v {
+        Value::String(s) => s.clone(),
+        other => other.to_string(),
+    }
+}
+
+/// Run `spec` for one call.  A supervisor thread reads the command's
+/// stdout and waits for it to exit; the deadline is fired by `timer`.
+/// Dropping the returned future kills the command.
+pub(crate) async fn run(spec: &ExecSpec, args: &Value, timer: &Timer) -> ToolResult {
+    let argv = match spec.argv(args) {
+        Ok(argv) => argv,
+        Err(e) => return error_result(e),
+    };
+    let mut child = match Command::new(&spec.command)
+        .args(argv)
+        .env_clear()
+        .envs(spec.env.iter().filter_map(|(var, name)| Some((var, plain(args.get(name)?)))))
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+    {
+        Ok(child) => child,
+        Err(e) => return error_result(format!("failed to start {}: {}", spec.command, e)),
+    };
+
+    // Stdin and stderr get threads of their own so a child blocked on one
+    // pipe can't stall the others.  Without input, stdin closes at once.
+    let input = spec.stdin.as_ref().and_then(|name| args.get(name)).map(plain);
+    if let (Some(mut stdin), Some(input)) = (child.stdin.take(), input) {
+        std::thread::spawn(move || {
+            let _ = stdin.write_all(input.as_bytes());
+        });
+    }
+    let stdout = child.stdout.take();
+    let stderr = child.stderr.take().map(|pipe| capture(pipe, STDERR_QUOTE));
+
+    let child = Arc::new(Mutex::new(child));
+    let _kill = KillOnDrop(Arc::clone(&child));
+    let timeout = spec.timeout();
+    let deadline = cancel::Deadline::start(timer, timeout);
+    let (reply, recv) = cancel::reply_channel();
+    let (max_output, program) = (spec.max_output(), spec.command.clone());
+    std::thread::spawn(move || {
+        let _ = reply.send(supervise(&child, stdout, stderr, max_output, &program));
+    });
+    match cancel::cancellable(&deadline.token, recv).await {
+        Some(Some(result)) => result,
+        Some(None) => error_result("command runner exited unexpectedly"),
+        None => {
+            error_result(format!("{} timed out after {}ms", spec.command, timeout.as_millis()))
+        }
+    }
+}
+
+/// Kills the command when its call ends early: at the deadline, or when
+/// the caller drops the future.  Killing closes the command's stdout, which
+/// frees the supervisor.  A command that has closed stdout already is being
+/// waited on and is left to exit by itself.
+struct KillOnDrop(Arc<Mutex<Child>>);
+
+impl Drop for KillOnDrop {
+    fn drop(&mut self) {
+        let mut child = match self.0.try_lock() {
+            Ok(child) => child,
+            Err(TryLockError::Poisoned(e)) => e.into_inner(),
+            Err(TryLockError::WouldBlock) => return,
+        };
+        let _ = child.kill();
+    }
+}
+
+/// Read up to `max_output` bytes of stdout, then wait for the command to
+/// exit.  Going over the cap kills it.  Blocks the calling thread.
+fn supervise(
+    child: &Mutex<Child>,
+    stdout: Option<ChildStdout>,
+    stderr: Option<JoinHandle<Vec<u8>>>,
+    max_output: usize,
+    program: &str,
+) -> ToolResult {
+    let mut output = Vec::new();
+    if let Some(pipe) = stdout {
+        let _ = pipe.take(max_output as u64 + 1).read_to_end(&mut output);
+    }
+    let overflow = output.len() > max_output;
+    let status = {
+        let mut child = child.lock().unwrap_or_else(|e| e.into_inner());
+        if overflow {
+            let _ = child.kill();
+        }
+        child.wait()
+    };
+    let stderr = stderr.and_then(|t| t.join().ok()).unwrap_or_default();
+
+    if overflow {
+        return error_result(format!(
+            "{} produced more than {} bytes of output",
+            program, max_output
+        ));
+    }
+    let status = match status {
+        Ok(status) => status,
+        Err(e) => return error_result(format!("waiting for {}: {}", program, e)),
+    };
+    if !status.success() {
+        let stderr = String::from_utf8_lossy(&stderr);
+        return error_result(format!("{} failed ({}): {}", program, status, stderr.trim()));
+    }
+    text_result(String::from_utf8_lossy(&output))
+}
+
+/// Read up to `limit` bytes from a pipe on a new thread, then drain and
+/// discard the rest so the child never blocks on it.
+fn capture(mut pipe: impl Read + Send + 'static, limit: usize) -> JoinHandle<Vec<u8>> {
+    std::thread::spawn(move || {
+        let mut buf = Vec::new();
+        let _ = (&mut pipe).take(limit as u64).read_to_end(&mut buf);
+        let _ = std::io::copy(&mut pipe, &mut std::io::sink());
+        buf
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::clock::{ManualClock, SystemClock};
+    use serde_json::json;
+
+    fn timer() -> Timer {
+        Timer::new(Arc::new(SystemClock))
+    }
+
+    fn sh(script: &str) -> ExecSpec {
+        ExecSpec {
+            command: "/bin/sh".into(),
+            // `sh` names `$0`, so positionals start at `$1`.
+            args: vec!["-c".into(), script.into(), "sh".into()],
+            flags: BTreeMap::new(),
+            positional: Vec::new(),
+            env: BTreeMap::new(),
+            stdin: None,
+            timeout_ms: None,
+            max_output_bytes: None,
+            allow_dash_values: false,
+            end_of_options: false,
+        }
+    }
+
+    fn text(result: &ToolResult) -> &str {
+        result.content[0].text.as_deref().unwrap()
+    }
+
+    #[test]
+    fn test_argv_mapping() {
+        let mut spec = sh("");
+        spec.args = vec!["+short".into()];
+        spec.flags = BTreeMap::from([("-t".into(), "type".into()), ("-v".into(), "verbose".into()), ("-x".into(), "missing".into())]);
+        spec.positional = vec!["domain".into(), "port".into()];
+        let argv = spec.argv(&json!({"type": "MX", "verbose": true, "domain": "example.com", "port": 53}));
+        assert_eq!(argv.unwrap(), vec!["+short", "-t", "MX", "-v", "example.com", "53"]);
+
+        // Values can't smuggle in options of their own.
+        let err = spec.argv(&json!({"type": "--output=/etc/passwd", "domain": "x"})).unwrap_err();
+        assert_eq!(err, "argument type must not start with '-'");
+        assert!(spec.argv(&json!({"domain": "-oProxyCommand=x"})).is_err());
+        spec.allow_dash_values = true;
+        assert_eq!(spec.argv(&json!({"port": -1})).unwrap(), vec!["+short", "-1"]);
+
+        // `--` only when asked for, and only before positionals.
+        spec.end_of_options = true;
+        assert_eq!(spec.argv(&json!({"port": 53})).unwrap(), vec!["+short", "--", "53"]);
+        assert_eq!(spec.argv(&json!({})).unwrap(), vec!["+short"]);
+    }
+
+    #[tokio::test]
+    async fn test_run_maps_env_positional_and_stdin() {
+        let mut spec = sh(r#"printf '%s|%s|%s|' "$1" "$GREETING" "$HOME"; while read -r line; do printf '%s' "$line"; done"#);
+        spec.positional = vec!["name".into()];
+        spec.env = BTreeMap::from([("GREETING".into(), "greeting".into())]);
+        spec.stdin = Some("body".into());
+        let result = run(&spec, &json!({"name": "ada", "greeting": "hi", "body": "one\ntwo\n"}), &timer()).await;
+        assert!(!result.is_error);
+        assert_eq!(text(&result), "ada|hi||onetwo");
+    }
+
+    #[tokio::test]
+    async fn test_run_limits() {
+        // The deadline runs on the injected clock, so stepping it times the
+        // call out without waiting.
+        let clock = Arc::new(ManualClock::new());
+        let manual = Timer::new(clock.clone());
+        let mut slow = sh("while :; do :; done");
+        slow.timeout_ms = Some(50);
+        let advance = async {
+            loop {
+                tokio::task::yield_now().await;
+                clock.advance(Duration::from_millis(50));
+            }
+        };
+        let args = json!({});
+        let result = tokio::select! {
+            result = run(&slow, &args, &manual) => result,
+            _ = advance => unreachable!(),
+        };
+        assert!(result.is_error);
+        assert!(text(&result).contains("timed out after 50ms"), "{}", text(&result));
+
+        let mut loud = sh("while :; do printf xxxxxxxx; done");
+        loud.max_output_bytes = Some(16);
+        let result = run(&loud, &json!({}), &timer()).await;
+        assert!(text(&result).contains("more than 16 bytes"), "{}", text(&result));
+
+        let result = run(&sh("printf oops >&2; exit 3"), &json!({}), &timer()).await;
+        assert!(result.is_error);
+        assert!(text(&result).ends_with("oops"), "{}", text(&result));
+
+        let mut missing = sh("");
+        missing.command = "/nonexistent/tool".into();
+        assert!(text(&run(&missing, &json!({}), &timer()).await).starts_with("failed to start /nonexistent/tool"));
+    }
+}
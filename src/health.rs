@@ -0,0 +1,94 @@
+use std::collections::BTreeMap;
+use std::sync::RwLock;
+
+use async_trait::async_trait;
+
+/// A named probe of something tools depend on, e.g. a warehouse or a
+/// partner API.
+///
+/// Tools list the checks they need in `dependsOn`.  While a check is
+/// failing those tools are hidden from `tools/list` and calls to them get a
+/// retryable error.  The crate has no timer of its own: the application
+/// calls [`Server::run_health_checks`](crate::Server::run_health_checks)
+/// on its own schedule, or reports results with
+/// [`Server::set_dependency_health`](crate::Server::set_dependency_health).
+#[async_trait]
+pub trait HealthCheck: Send + Sync {
+    /// The name tools refer to in `dependsOn`.
+    fn name(&self) -> &str;
+
+    /// `Err` carries a short reason, shown to clients.
+    async fn check(&self) -> Result<(), String>;
+}
+
+/// Wraps an async closure as a [`HealthCheck`].
+pub struct FnHealthCheck<F> {
+    name: String,
+    f: F,
+}
+
+impl<F, Fut> FnHealthCheck<F>
+where
+    F: Fn() -> Fut + Send + Sync + 'static,
+    Fut: std::future::Future<Output = Result<(), String>> + Send + 'static,
+{
+    pub fn new(name: impl Into<String>, f: F) -> Self {
+        FnHealthCheck {
+            name: name.into(),
+            f,
+        }
+    }
+}
+
+#[async_trait]
+impl<F, Fut> HealthCheck for FnHealthCheck<F>
+where
+    F: Fn() -> Fut + Send + Sync + 'static,
+    Fut: std::future::Future<Output = Result<(), String>> + Send + 'static,
+{
+    fn name(&self) -> &str {
+        &self.name
+    }
+
+    async fn check(&self) -> Result<(), String> {
+        (self.f)().await
+    }
+}
+
+/// The failing dependencies and why.  Dependencies never reported are
+/// assumed healthy.
+#[derive(Default)]
+pub(crate) struct HealthBoard {
+    failing: RwLock<BTreeMap<String, String>>,
+}
+
+impl HealthBoard {
+    /// Record a result.  Returns true when the dependency changed between
+    /// healthy and failing.
+    pub fn set(&self, name: &str, result: Result<(), String>) -> bool {
+        let mut failing = self.failing.write().unwrap_or_else(|e| e.into_inner());
+        match result {
+            Ok(()) => failing.remove(name).is_some(),
+            Err(reason) => failing.insert(name.to_string(), reason).is_none(),
+        }
+    }
+
+    /// The first of `dependencies` that is failing, with its reason.
+    pub fn first_failing(&self, dependencies: &[String]) -> Option<(String, String)> {
+        if dependencies.is_empty() {
+            return None;
+        }
+        let failing = self.failing.read().unwrap_or_else(|e| e.into_inner());
+        dependencies
+            .iter()
+            .find_map(|d| failing.get(d).map(|reason| (d.clone(), reason.clone())))
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.failing.read().unwrap_or_else(|e| e.into_inner()).is_empty()
+    }
+
+    pub fn failing(&self) -> BTreeMap<String, String> {
+        self.failing.read().unwrap_or_else(|e| e.into_inner()).clone()
+    }
+}
@@ -30,15 +30,74 @@
 //! # }
 //! ```
 
+pub mod budget;
+mod cancel;
+mod catalog;
+pub mod clock;
+pub mod compat;
+pub mod debug;
+mod dedup;
+pub mod diff;
+pub mod events;
+pub mod exec;
+pub mod filter;
+pub mod health;
+pub mod lint;
 pub mod loader;
+pub mod locale;
+pub mod memory;
+pub mod metrics;
+mod outbound;
+pub mod patch;
+pub mod pipeline;
+mod prompt;
+pub mod query;
+pub mod render;
+pub mod sampling;
 pub mod server;
+pub mod shape;
+pub mod source;
+mod timer;
+pub mod trace;
 pub mod types;
+mod uritemplate;
 mod validate;
+pub mod visibility;
 
 // Re-export the most commonly used items at the crate root.
-pub use loader::{load_resources, load_tools, parse_resources, parse_tools};
-pub use server::{FnToolHandler, ResourceHandler, Server, ServerBuilder, ToolHandler};
+pub use budget::LatencyAlert;
+pub use clock::{Clock, ManualClock, SystemClock};
+pub use compat::{check_backward_compatible, CompatIssue};
+pub use debug::DebugCapture;
+pub use diff::{diff_catalogs, CatalogDiff, ToolChange};
+pub use events::{Event, SubscriptionId};
+pub use filter::{FilterPolicy, Finding, InjectionScanner, OutputFilter, SecretScanner};
+pub use health::{FnHealthCheck, HealthCheck};
+pub use loader::{
+    load_prompts, load_resource_templates, load_resources, load_tools, parse_prompts,
+    parse_resource_templates, parse_resources, parse_tools,
+};
+pub use locale::{BasicLocalizer, Localizer};
+pub use memory::{MemoryClient, MemoryTransport};
+pub use metrics::{EmfSink, MetricsSink};
+pub use patch::apply_patch;
+pub use query::{QueryEngine, TableQuery};
+pub use render::{CsvToJson, JsonToCsv, Renderer};
+pub use sampling::{RequestSample, Sampling};
+pub use server::{
+    CatalogPatchFn, CompletionHandler, FnCompletionHandler, FnMethodHandler, FnPromptHandler,
+    FnToolHandler, MethodHandler, NotificationFn, PromptHandler, RequestFn,
+    ResourceContentsHandler, ResourceHandler, ResourceTemplateHandler, ResourceWriteHandler,
+    Server, ServerBuilder, SpawnFn, ToolHandler,
+};
+pub use shape::{FlatText, ResultShape};
+pub use source::{CatalogSource, EmbeddedSource, FileSource, FnSource, LoadedCatalog, SourceChain};
 pub use types::{
-    error_result, new_error_response, text_result, ContentBlock, JsonRpcRequest, JsonRpcResponse,
-    McpError, McpResponse, Resource, ResourceContent, RpcError, Tool, ToolResult, PROTOCOL_VERSION,
+    error_result, image_result, negotiate_protocol_version, new_error_response, parse_request,
+    structured_result, text_message, text_result, ClientCapabilities, ClientInfo, Completion,
+    CompletionRef, ContentBlock, CreateMessageRequest, CreateMessageResult, Icon,
+    JsonRpcNotification, JsonRpcRequest, JsonRpcResponse,
+    ListChangedCapability, LogLevel, McpError, McpResponse, Prompt, PromptArgument, PromptMessage,
+    Resource, ResourceContent, ResourceTemplate, ResourcesCapability, Root, RpcError, ServerCapabilities,
+    ServerRequest, Tool, ToolAnnotations, ToolResult, PROTOCOL_VERSION, SUPPORTED_PROTOCOL_VERSIONS,
 };
@@ -0,0 +1,202 @@
+use std::collections::HashMap;
+use std::fmt;
+
+use serde_json::Value;
+
+use crate::types::Tool;
+
+/// A catalog quality check run over tool schemas at load time.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum LintRule {
+    /// A tool or one of its properties has no description.
+    MissingDescription,
+    /// A property declares no `type`.
+    MissingType,
+    /// A `required` field is not declared in `properties`.
+    RequiredNotInProperties,
+    /// The input schema accepts arbitrary objects: no properties, or
+    /// `additionalProperties: true`.
+    PermissiveSchema,
+}
+
+impl LintRule {
+    pub const ALL: [LintRule; 4] = [
+        LintRule::MissingDescription,
+        LintRule::MissingType,
+        LintRule::RequiredNotInProperties,
+        LintRule::PermissiveSchema,
+    ];
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            LintRule::MissingDescription => "missing-description",
+            LintRule::MissingType => "missing-type",
+            LintRule::RequiredNotInProperties => "required-not-in-properties",
+            LintRule::PermissiveSchema => "permissive-schema",
+        }
+    }
+}
+
+/// What to do about a rule's findings.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Severity {
+    Off,
+    /// Logged at warn level.
+    #[default]
+    Warn,
+    /// Logged at error level; fails [`ServerBuilder::try_build`].
+    ///
+    /// [`ServerBuilder::try_build`]: crate::ServerBuilder::try_build
+    Error,
+}
+
+/// Per-rule severities.  Every rule warns unless configured otherwise.
+#[derive(Debug, Clone, Default)]
+pub struct LintConfig {
+    severities: HashMap<LintRule, Severity>,
+}
+
+impl LintConfig {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Set the severity of one rule.
+    pub fn rule(mut self, rule: LintRule, severity: Severity) -> Self {
+        self.severities.insert(rule, severity);
+        self
+    }
+
+    /// Set the severity of every rule.
+    pub fn all(mut self, severity: Severity) -> Self {
+        for rule in LintRule::ALL {
+            self.severities.insert(rule, severity);
+        }
+        self
+    }
+
+    pub fn severity(&self, rule: LintRule) -> Severity {
+        self.severities.get(&rule).copied().unwrap_or_default()
+    }
+}
+
+/// One finding.
+#[derive(Debug, Clone, PartialEq)]
+pub struct LintIssue {
+    pub tool: String,
+    pub rule: LintRule,
+    pub severity: Severity,
+    pub message: String,
+}
+
+impl fmt::Display for LintIssue {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}: {} ({})", self.tool, self.message, self.rule.as_str())
+    }
+}
+
+/// Lint `tools`, returning findings for every rule not turned off.
+pub fn lint_tools(tools: &[Tool], config: &LintConfig) -> Vec<LintIssue> {
+    let mut issues = Vec::new();
+    for tool in tools {
+        let mut report = |rule: LintRule, message: String| {
+            let severity = config.severity(rule);
+            if severity != Severity::Off {
+                issues.push(LintIssue {
+                    tool: tool.name.clone(),
+                    rule,
+                    severity,
+                    message,
+                });
+            }
+        };
+
+        if tool.description.trim().is_empty() {
+            report(LintRule::MissingDescription, "tool has no description".into());
+        }
+
+        let schema = &tool.input_schema;
+        let empty = serde_json::Map::new();
+        let properties = schema.get("properties").and_then(Value::as_object).unwrap_or(&empty);
+        if properties.is_empty() && schema.get("oneOf").is_none() {
+            report(LintRule::PermissiveSchema, "input schema declares no properties".into());
+        }
+        if schema.get("additionalProperties") == Some(&Value::Bool(true)) {
+            report(LintRule::PermissiveSchema, "input schema allows additionalProperties".into());
+        }
+
+        for (name, prop) in properties {
+            if prop.get("description").and_then(Value::as_str).is_none_or(|d| d.trim().is_empty()) {
+                report(LintRule::MissingDescription, format!("property \"{}\" has no description", name));
+            }
+            let typed = ["type", "enum", "const", "$ref", "oneOf", "anyOf", "allOf"]
+                .iter()
+                .any(|k| prop.get(*k).is_some());
+            if !typed {
+                report(LintRule::MissingType, format!("property \"{}\" has no type", name));
+            }
+        }
+
+        for field in schema.get("required").and_then(Value::as_array).into_iter().flatten() {
+            if let Some(field) = field.as_str().filter(|f| !properties.contains_key(*f)) {
+                report(
+                    LintRule::RequiredNotInProperties,
+                    format!("required field \"{}\" is not in properties", field),
+                );
+            }
+        }
+    }
+    issues
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::loader::parse_tools;
+
+    fn rules(json: &str, config: &LintConfig) -> Vec<(LintRule, String)> {
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        lint_tools(&tools, config).into_iter().map(|i| (i.rule, i.message)).collect()
+    }
+
+    #[test]
+    fn test_clean_schema_has_no_issues() {
+        let json = r#"[{"name":"t","description":"d","inputSchema":{"type":"object",
+            "properties":{"city":{"type":"string","description":"City name"}},"required":["city"]}}]"#;
+        assert!(rules(json, &LintConfig::new()).is_empty());
+    }
+
+    #[test]
+    fn test_each_rule() {
+        let json = r#"[
+            {"name":"a","description":"","inputSchema":{"type":"object","properties":{"x":{}},"required":["y"]}},
+            {"name":"b","description":"d","inputSchema":{"type":"object","additionalProperties":true}}
+        ]"#;
+        let found = rules(json, &LintConfig::new());
+        assert_eq!(
+            found.iter().map(|(r, _)| *r).collect::<Vec<_>>(),
+            vec![
+                LintRule::MissingDescription,
+                LintRule::MissingDescription,
+                LintRule::MissingType,
+                LintRule::RequiredNotInProperties,
+                LintRule::PermissiveSchema,
+                LintRule::PermissiveSchema,
+            ]
+        );
+        assert_eq!(found[3].1, "required field \"y\" is not in properties");
+    }
+
+    #[test]
+    fn test_severity_off_suppresses() {
+        let json = r#"[{"name":"a","description":"","inputSchema":{"type":"object"}}]"#;
+        let config = LintConfig::new()
+            .rule(LintRule::MissingDescription, Severity::Off)
+            .rule(LintRule::PermissiveSchema, Severity::Error);
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        let issues = lint_tools(&tools, &config);
+        assert_eq!(issues.len(), 1);
+        assert_eq!(issues[0].severity, Severity::Error);
+        assert_eq!(issues[0].to_string(), "a: input schema declares no properties (permissive-schema)");
+    }
+}
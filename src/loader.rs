@@ -1,9 +1,14 @@
 use std::collections::HashMap;
 use std::path::Path;
+use std::time::Duration;
 
-use serde_json::Value;
+use serde_json::{json, Value};
 
-use crate::types::{McpError, Resource, SchemaMeta, SchemaRequirementSet, Tool};
+use crate::filter::FilterPolicy;
+use crate::visibility::Visibility;
+use crate::types::{
+    McpError, Prompt, Resource, ResourceTemplate, SchemaMeta, SchemaRequirementSet, Tool,
+};
 
 /// Load tool definitions from a JSON file on disk.
 pub fn load_tools(path: impl AsRef<Path>) -> Result<Vec<Tool>, McpError> {
@@ -20,21 +25,149 @@ pub fn parse_tools(data: &[u8]) -> Result<Vec<Tool>, McpError> {
         let name = val["name"].as_str().unwrap_or_default().to_string();
         let description = val["description"].as_str().unwrap_or_default().to_string();
         let input_schema = val["inputSchema"].clone();
+        let output_schema = val.get("outputSchema").filter(|v| !v.is_null()).cloned();
 
         // Parse schema metadata for validation.
         let schema_meta = parse_schema_meta(&input_schema);
+        let output_schema_meta = output_schema
+            .as_ref()
+            .map(parse_schema_meta)
+            .unwrap_or_default();
+
+        let annotations = match val.get("annotations").filter(|v| !v.is_null()) {
+            Some(v) => Some(serde_json::from_value(v.clone()).map_err(|e| {
+                McpError::Validation(format!("tool {}: invalid annotations: {}", name, e))
+            })?),
+            None => None,
+        };
+
+        let output_policy = match val["outputPolicy"].as_str() {
+            Some(s) => Some(FilterPolicy::parse(s).ok_or_else(|| {
+                McpError::Validation(format!("tool {}: unknown outputPolicy {:?}", name, s))
+            })?),
+            None => None,
+        };
+
+        let publish_as = match val.get("publishAs").filter(|v| !v.is_null()) {
+            Some(v) => Some(serde_json::from_value(v.clone()).map_err(|e| {
+                McpError::Validation(format!("tool {}: invalid publishAs: {}", name, e))
+            })?),
+            None => None,
+        };
+
+        let steps = match val.get("steps").filter(|v| !v.is_null()) {
+            Some(v) => serde_json::from_value(v.clone()).map_err(|e| {
+                McpError::Validation(format!("tool {}: invalid steps: {}", name, e))
+            })?,
+            None => Vec::new(),
+        };
+
+        let visible_when = match val.get("visibleWhen").filter(|v| !v.is_null()) {
+            Some(v) => Some(
+                Visibility::parse(v).map_err(|e| McpError::Validation(format!("tool {}: {}", name, e)))?,
+            ),
+            None => None,
+        };
+
+        let icons = match val.get("icons").filter(|v| !v.is_null()) {
+            Some(v) => serde_json::from_value(v.clone()).map_err(|e| {
+                McpError::Validation(format!("tool {}: invalid icons: {}", name, e))
+            })?,
+            None => Vec::new(),
+        };
+
+        let meta = grouping_meta(&name, &val)?;
+
+        let exec = match val.get("exec").filter(|v| !v.is_null()) {
+            Some(v) => Some(serde_json::from_value(v.clone()).map_err(|e| {
+                McpError::Validation(format!("tool {}: invalid exec: {}", name, e))
+            })?),
+            None => None,
+        };
+
+        let requires_client = match val.get("requiresClientCapabilities").filter(|v| !v.is_null()) {
+            Some(v) => serde_json::from_value(v.clone()).map_err(|_| {
+                McpError::Validation(format!(
+                    "tool {}: requiresClientCapabilities must be an array of strings",
+                    name
+                ))
+            })?,
+            None => Vec::new(),
+        };
+        let depends_on = match val.get("dependsOn").filter(|v| !v.is_null()) {
+            Some(v) => serde_json::from_value(v.clone()).map_err(|_| {
+                McpError::Validation(format!("tool {}: dependsOn must be an array of strings", name))
+            })?,
+            None => Vec::new(),
+        };
 
         tools.push(Tool {
             name,
+            title: val["title"].as_str().map(String::from),
             description,
             input_schema,
+            output_schema,
+            annotations,
+            icons,
+            meta,
             schema_meta,
+            output_schema_meta,
+            examples: value_array(&val["examples"]),
+            counterexamples: value_array(&val["counterexamples"]),
+            latency_budget: val["latencyBudgetMs"].as_u64().map(Duration::from_millis),
+            timeout: val["timeoutMs"].as_u64().map(Duration::from_millis),
+            max_output_bytes: val["maxOutputBytes"].as_u64().map(|n| n as usize),
+            output_policy,
+            publish_as,
+            steps,
+            visible_when,
+            requires_client,
+            depends_on,
+            exec,
+            localize: val["localize"].as_bool().unwrap_or(false),
         });
     }
 
     Ok(tools)
 }
 
+/// The config form of `tool`: its tools/list fields plus the config-only
+/// ones (`timeoutMs`, `exec`, ...) that are set.  [`parse_tools`] reads it
+/// back into the same tool.
+pub(crate) fn tool_config(tool: &Tool) -> Value {
+    let mut val = json!(tool);
+    let Some(fields) = val.as_object_mut() else {
+        return val;
+    };
+    let millis = |d: Duration| json!(d.as_millis() as u64);
+    let config = [
+        ("examples", listed(json!(tool.examples))),
+        ("counterexamples", listed(json!(tool.counterexamples))),
+        ("latencyBudgetMs", tool.latency_budget.map(millis)),
+        ("timeoutMs", tool.timeout.map(millis)),
+        ("maxOutputBytes", tool.max_output_bytes.map(|n| json!(n))),
+        ("outputPolicy", tool.output_policy.map(|p| json!(p.as_str()))),
+        ("publishAs", tool.publish_as.as_ref().map(|r| json!(r))),
+        ("steps", listed(json!(tool.steps))),
+        ("visibleWhen", tool.visible_when.as_ref().map(Visibility::to_value)),
+        ("requiresClientCapabilities", listed(json!(tool.requires_client))),
+        ("dependsOn", listed(json!(tool.depends_on))),
+        ("exec", tool.exec.as_ref().map(|e| json!(e))),
+        ("localize", tool.localize.then_some(Value::Bool(true))),
+    ];
+    for (key, value) in config {
+        if let Some(value) = value {
+            fields.insert(key.into(), value);
+        }
+    }
+    val
+}
+
+/// `list` unless it is an empty array.
+fn listed(list: Value) -> Option<Value> {
+    Some(list).filter(|v| v.as_array().is_none_or(|a| !a.is_empty()))
+}
+
 /// Load resource definitions from a JSON file on disk.
 pub fn load_resources(path: impl AsRef<Path>) -> Result<Vec<Resource>, McpError> {
     let data = std::fs::read(path)?;
@@ -47,6 +180,62 @@ pub fn parse_resources(data: &[u8]) -> Result<Vec<Resource>, McpError> {
     Ok(resources)
 }
 
+/// Load resource template definitions from a JSON file on disk.
+pub fn load_resource_templates(path: impl AsRef<Path>) -> Result<Vec<ResourceTemplate>, McpError> {
+    let data = std::fs::read(path)?;
+    parse_resource_templates(&data)
+}
+
+/// Parse resource template definitions from raw JSON bytes.
+pub fn parse_resource_templates(data: &[u8]) -> Result<Vec<ResourceTemplate>, McpError> {
+    let templates: Vec<ResourceTemplate> = serde_json::from_slice(data)?;
+    Ok(templates)
+}
+
+/// Load prompt definitions from a JSON file on disk.
+pub fn load_prompts(path: impl AsRef<Path>) -> Result<Vec<Prompt>, McpError> {
+    let data = std::fs::read(path)?;
+    parse_prompts(&data)
+}
+
+/// Parse prompt definitions from raw JSON bytes.
+pub fn parse_prompts(data: &[u8]) -> Result<Vec<Prompt>, McpError> {
+    let prompts: Vec<Prompt> = serde_json::from_slice(data)?;
+    Ok(prompts)
+}
+
+/// The tool's `_meta` with its `category` and `tags` config fields moved
+/// in, which is where tools/list serves them.
+fn grouping_meta(name: &str, val: &Value) -> Result<Option<Value>, McpError> {
+    let mut meta = val.get("_meta").filter(|v| !v.is_null()).cloned();
+    let category = val.get("category").filter(|v| !v.is_null());
+    let tags = val.get("tags").filter(|v| !v.is_null());
+    let invalid = |message: &str| McpError::Validation(format!("tool {}: {}", name, message));
+    if category.is_some_and(|v| !v.is_string()) {
+        return Err(invalid("category must be a string"));
+    }
+    if tags.is_some_and(|v| !v.as_array().is_some_and(|a| a.iter().all(Value::is_string))) {
+        return Err(invalid("tags must be an array of strings"));
+    }
+
+    for (key, value) in [("category", category), ("tags", tags)] {
+        let Some(value) = value else {
+            continue;
+        };
+        let fields = meta
+            .get_or_insert_with(|| Value::Object(Default::default()))
+            .as_object_mut()
+            .ok_or_else(|| invalid("_meta must be an object"))?;
+        fields.insert(key.into(), value.clone());
+    }
+    Ok(meta)
+}
+
+/// Clone the elements of a JSON array, or nothing when absent.
+fn value_array(val: &Value) -> Vec<Value> {
+    val.as_array().cloned().unwrap_or_default()
+}
+
 /// Extract validation metadata from a JSON Schema object.
 fn parse_schema_meta(schema: &Value) -> SchemaMeta {
     let mut meta = SchemaMeta::default();
@@ -113,6 +302,39 @@ mod tests {
         assert_eq!(resources[0].uri, "s3://bucket/file.csv");
     }
 
+    #[test]
+    fn test_parse_resources_and_prompts_with_title() {
+        let json = r#"[{"name":"forecast","title":"Monthly forecast","description":"monthly",
+            "uri":"s3://bucket/file.csv","mimeType":"text/csv","icons":[{"src":"data:image/png;base64,AA=="}]}]"#;
+        let resources = parse_resources(json.as_bytes()).unwrap();
+        assert_eq!(resources[0].title.as_deref(), Some("Monthly forecast"));
+        assert_eq!(resources[0].icons.len(), 1);
+
+        let json = r#"[{"name":"greet","title":"Greeting","messages":[]}]"#;
+        let prompts = parse_prompts(json.as_bytes()).unwrap();
+        let out = serde_json::to_value(&prompts[0]).unwrap();
+        assert_eq!(out["title"], "Greeting");
+        assert!(out.get("icons").is_none());
+        assert!(out.get("_meta").is_none());
+    }
+
+    #[test]
+    fn test_parse_resource_templates() {
+        let json = r#"[{"uriTemplate":"channel://{channelId}/messages","name":"channel_messages","mimeType":"application/json"}]"#;
+        let templates = parse_resource_templates(json.as_bytes()).unwrap();
+        assert_eq!(templates[0].uri_template, "channel://{channelId}/messages");
+        assert!(templates[0].description.is_none());
+    }
+
+    #[test]
+    fn test_parse_prompts() {
+        let json = r#"[{"name":"greet","arguments":[{"name":"who","required":true}],"messages":[{"role":"user","content":{"type":"text","text":"Hi {{who}}"}}]}]"#;
+        let prompts = parse_prompts(json.as_bytes()).unwrap();
+        assert_eq!(prompts[0].name, "greet");
+        assert!(prompts[0].arguments[0].required);
+        assert_eq!(prompts[0].messages.len(), 1);
+    }
+
     #[test]
     fn test_load_tools_missing_file() {
         let result = load_tools("/nonexistent/path.json");
@@ -132,6 +354,117 @@ mod tests {
         assert_eq!(tools[0].schema_meta.one_of.len(), 2);
     }
 
+    #[test]
+    fn test_parse_tools_with_examples() {
+        let json = r#"[{"name":"e","description":"e","inputSchema":{"type":"object"},"examples":[{"a":1}],"counterexamples":[{},{"b":2}]}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert_eq!(tools[0].examples.len(), 1);
+        assert_eq!(tools[0].counterexamples.len(), 2);
+    }
+
+    #[test]
+    fn test_parse_tools_category_and_tags() {
+        let json = r#"[{"name":"refund","description":"r","inputSchema":{"type":"object"},
+            "category":"billing","tags":["payments","write"],"_meta":{"owner":"team-pay"}}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert_eq!(tools[0].category(), Some("billing"));
+        assert_eq!(tools[0].tags(), vec!["payments", "write"]);
+        assert!(tools[0].in_group("billing") && tools[0].in_group("write") && !tools[0].in_group("read"));
+        assert_eq!(
+            tools[0].meta,
+            Some(serde_json::json!({"owner": "team-pay", "category": "billing", "tags": ["payments", "write"]}))
+        );
+
+        for bad in [r#""category":["billing"]"#, r#""tags":"payments""#, r#""tags":["a"],"_meta":[]"#] {
+            let json = format!(r#"[{{"name":"t","description":"t","inputSchema":{{"type":"object"}},{}}}]"#, bad);
+            assert!(parse_tools(json.as_bytes()).is_err(), "{bad}");
+        }
+    }
+
+    #[test]
+    fn test_parse_tools_annotations() {
+        let json = r#"[{"name":"delete_account","description":"d","inputSchema":{"type":"object"},
+            "annotations":{"destructiveHint":true,"idempotentHint":true}}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        let annotations = tools[0].annotations.as_ref().unwrap();
+        assert_eq!(annotations.destructive_hint, Some(true));
+        assert_eq!(annotations.read_only_hint, None);
+
+        let json = r#"[{"name":"a","description":"a","inputSchema":{"type":"object"},"annotations":{"readOnlyHint":"yes"}}]"#;
+        assert!(parse_tools(json.as_bytes()).is_err());
+    }
+
+    #[test]
+    fn test_parse_tools_display_metadata() {
+        let json = r#"[{"name":"geocode","title":"Geocode an address","description":"g",
+            "inputSchema":{"type":"object"},
+            "icons":[{"src":"https://example.com/g.png","mimeType":"image/png","sizes":["48x48"]}],
+            "_meta":{"team":"maps"}}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert_eq!(tools[0].title.as_deref(), Some("Geocode an address"));
+        assert_eq!(tools[0].icons[0].sizes, vec!["48x48"]);
+        assert_eq!(tools[0].meta.as_ref().unwrap()["team"], "maps");
+
+        let out = serde_json::to_value(&tools[0]).unwrap();
+        assert_eq!(out["title"], "Geocode an address");
+        assert_eq!(out["icons"][0]["mimeType"], "image/png");
+        assert_eq!(out["_meta"]["team"], "maps");
+
+        let json = r#"[{"name":"a","description":"a","inputSchema":{"type":"object"},"icons":[{"size":1}]}]"#;
+        assert!(parse_tools(json.as_bytes()).is_err());
+    }
+
+    #[test]
+    fn test_parse_tools_output_schema() {
+        let json = r#"[{"name":"a","description":"a","inputSchema":{"type":"object"},
+            "outputSchema":{"type":"object","properties":{"lat":{"type":"number"}},"required":["lat"]}}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert!(tools[0].output_schema.is_some());
+        assert_eq!(tools[0].output_schema_meta.required, vec!["lat"]);
+    }
+
+    #[test]
+    fn test_parse_tools_with_execution_limits() {
+        let json = r#"[{"name":"ext","description":"e","inputSchema":{"type":"object"},"timeoutMs":2000,"maxOutputBytes":4096}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert_eq!(tools[0].timeout, Some(Duration::from_secs(2)));
+        assert_eq!(tools[0].max_output_bytes, Some(4096));
+    }
+
+    #[test]
+    fn test_tool_config_round_trips() {
+        let json = r#"[{"name":"ext","description":"e","inputSchema":{"type":"object"},"category":"net",
+            "examples":[{}],"timeoutMs":2000,"maxOutputBytes":4096,"outputPolicy":"redact",
+            "publishAs":{"name":"last","description":"l","uri":"mem://last","mimeType":"text/plain"},
+            "steps":[{"tool":"a","arguments":{}}],"visibleWhen":{"context.beta":true,"protocolVersion":{"gte":"2025-06-18"}},
+            "requiresClientCapabilities":["sampling"],"dependsOn":["db"],"localize":true,
+            "exec":{"command":"/bin/true","endOfOptions":true}}]"#;
+        let config = tool_config(&parse_tools(json.as_bytes()).unwrap()[0]);
+        assert_eq!(config["timeoutMs"], 2000);
+        assert_eq!(config["visibleWhen"]["protocolVersion"]["gte"], "2025-06-18");
+        assert_eq!(config["exec"]["endOfOptions"], true);
+        assert!(config.get("counterexamples").is_none());
+
+        let again = parse_tools(&serde_json::to_vec(&[&config]).unwrap()).unwrap();
+        assert_eq!(tool_config(&again[0]), config);
+    }
+
+    #[test]
+    fn test_parse_tools_output_policy() {
+        let json = r#"[{"name":"a","description":"a","inputSchema":{"type":"object"},"outputPolicy":"block"}]"#;
+        assert_eq!(parse_tools(json.as_bytes()).unwrap()[0].output_policy, Some(FilterPolicy::Block));
+
+        let json = r#"[{"name":"a","description":"a","inputSchema":{"type":"object"},"outputPolicy":"shred"}]"#;
+        assert!(parse_tools(json.as_bytes()).is_err());
+    }
+
+    #[test]
+    fn test_parse_tools_with_latency_budget() {
+        let json = r#"[{"name":"slow","description":"s","inputSchema":{"type":"object"},"latencyBudgetMs":250}]"#;
+        let tools = parse_tools(json.as_bytes()).unwrap();
+        assert_eq!(tools[0].latency_budget, Some(Duration::from_millis(250)));
+    }
+
     #[test]
     fn test_parse_tools_with_dependencies() {
         let json = r#"[{"name":"ch","description":"ch","inputSchema":{"type":"object","properties":{},"dependencies":{"geo_lat":["geo_lon"]}}}]"#;
@@ -0,0 +1,204 @@
+/// Rewrites dates and numbers in tool text for a session's locale.
+///
+/// Runs on the text content blocks of tools that opt in with `localize`;
+/// `structuredContent` is left machine-readable.  Set a replacement (e.g.
+/// one backed by ICU) with
+/// [`ServerBuilder::localizer`](crate::server::ServerBuilder::localizer).
+pub trait Localizer: Send + Sync {
+    /// `locale` is a BCP 47 tag such as `es-AR`.  Return `text` unchanged
+    /// for locales you don't handle.
+    fn localize(&self, text: &str, locale: &str) -> String;
+}
+
+/// The built-in [`Localizer`].  It assumes tool output uses US
+/// conventions: dates as `YYYY-MM-DD` or `MM/DD/YYYY`, numbers with `,`
+/// grouping and `.` decimals.  It rewrites them for a small table of
+/// languages and regions.
+///
+/// Versions (`1.2.3`), times, timestamps (`2024-05-01T10:00`) and runs
+/// touching letters (`v2.5`, `3.5GB`) are left alone.  Unknown locales,
+/// and `en-US`, get the text back unchanged.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct BasicLocalizer;
+
+/// How a locale writes dates and numbers.
+#[derive(Debug, Clone, Copy, PartialEq)]
+struct Conventions {
+    order: DateOrder,
+    date_sep: char,
+    group: char,
+    decimal: char,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum DateOrder {
+    Dmy,
+    Ymd,
+}
+
+/// Decimal-point Spanish-speaking regions; the rest use a decimal comma.
+const ES_DECIMAL_POINT: &[&str] = &["MX", "US", "GT", "HN", "SV", "NI", "PA", "DO", "PR", "CU"];
+
+fn conventions(locale: &str) -> Option<Conventions> {
+    // Accept an Accept-Language value too: take its first tag.
+    let tag = locale.split([',', ';']).next()?.trim();
+    let mut parts = tag.split(['-', '_']);
+    let lang = parts.next()?.to_ascii_lowercase();
+    let region = parts
+        .find(|p| p.len() == 2 && p.chars().all(|c| c.is_ascii_alphabetic()))
+        .map(|r| r.to_ascii_uppercase());
+    let region = region.as_deref();
+
+    // (grouping, decimal) separator pairs.
+    let us = (',', '.');
+    let eu = ('.', ',');
+    let space = ('\u{a0}', ',');
+    use DateOrder::*;
+    let (order, date_sep, (group, decimal)) = match lang.as_str() {
+        "en" => match region {
+            // Already in the source conventions.
+            None | Some("US") | Some("PH") => return None,
+            _ => (Dmy, '/', us),
+        },
+        "es" => match region {
+            Some(r) if ES_DECIMAL_POINT.contains(&r) => (Dmy, '/', us),
+            _ => (Dmy, '/', eu),
+        },
+        "pt" | "it" | "id" => (Dmy, '/', eu),
+        "de" | "tr" | "da" => (Dmy, '.', eu),
+        "nl" => (Dmy, '-', eu),
+        "fr" => (Dmy, '/', space),
+        "sv" => (Ymd, '-', space),
+        "nb" | "fi" | "cs" | "pl" | "ru" | "uk" => (Dmy, '.', space),
+        "ja" | "zh" | "ko" => (Ymd, '/', us),
+        _ => return None,
+    };
+    Some(Conventions {
+        order,
+        date_sep,
+        group,
+        decimal,
+    })
+}
+
+impl Localizer for BasicLocalizer {
+    fn localize(&self, text: &str, locale: &str) -> String {
+        let Some(conv) = conventions(locale) else {
+            return text.to_string();
+        };
+        let mut out = String::with_capacity(text.len());
+        let mut rest = text;
+        while let Some(start) = rest.find(|c: char| c.is_ascii_digit()) {
+            // A run of digits and separators, trimmed of trailing punctuation.
+            let len = rest[start..]
+                .find(|c: char| !(c.is_ascii_digit() || matches!(c, '.' | ',' | '/' | '-')))
+                .unwrap_or(rest.len() - start);
+            let mut run = &rest[start..start + len];
+            while run.ends_with(['.', ',', '/', '-']) {
+                run = &run[..run.len() - 1];
+            }
+            let end = start + run.len();
+            let before = rest[..start].chars().next_back();
+            let after = rest[end..].chars().next();
+            let boundary = !before
+                .is_some_and(|c| c.is_alphanumeric() || matches!(c, '.' | ':' | '_'))
+                && !after.is_some_and(|c| c.is_alphanumeric() || matches!(c, ':' | '_'));
+
+            out.push_str(&rest[..start]);
+            match boundary.then(|| rewrite(run, conv)).flatten() {
+                Some(localized) => out.push_str(&localized),
+                None => out.push_str(run),
+            }
+            rest = &rest[end..];
+        }
+        out.push_str(rest);
+        out
+    }
+}
+
+fn rewrite(run: &str, conv: Conventions) -> Option<String> {
+    date(run, conv).or_else(|| number(run, conv))
+}
+
+fn digits(s: &str, len: usize) -> Option<u32> {
+    (s.len() == len && s.bytes().all(|b| b.is_ascii_digit())).then(|| s.parse().ok())?
+}
+
+/// `YYYY-MM-DD` or `MM/DD/YYYY` in the locale's order.
+fn date(run: &str, conv: Conventions) -> Option<String> {
+    let (y, m, d) = match run.split('-').collect::<Vec<_>>()[..] {
+        [y, m, d] => (digits(y, 4)?, digits(m, 2)?, digits(d, 2)?),
+        _ => match run.split('/').collect::<Vec<_>>()[..] {
+            [m, d, y] => (digits(y, 4)?, digits(m, 2)?, digits(d, 2)?),
+            _ => return None,
+        },
+    };
+    if !(1..=12).contains(&m) || !(1..=31).contains(&d) {
+        return None;
+    }
+    let s = conv.date_sep;
+    Some(match conv.order {
+        DateOrder::Dmy => format!("{:02}{s}{:02}{s}{}", d, m, y),
+        DateOrder::Ymd => format!("{}{s}{:02}{s}{:02}", y, m, d),
+    })
+}
+
+/// `1,234,567.89`, `1,234` or `3.14` with the locale's separators.  A
+/// plain `3.14` has no grouping to check, so it is only rewritten when it
+/// can't be a version or an IP address (exactly one `.`).
+fn number(run: &str, conv: Conventions) -> Option<String> {
+    if run.contains(['/', '-']) {
+        return None;
+    }
+    let (int, frac) = match run.split_once('.') {
+        Some((int, frac)) if !frac.is_empty() && frac.bytes().all(|b| b.is_ascii_digit()) => {
+            (int, Some(frac))
+        }
+        Some(_) => return None,
+        None => (run, None),
+    };
+    let groups: Vec<&str> = int.split(',').collect();
+    let grouped = groups.len() > 1;
+    let valid = !groups[0].is_empty()
+        && groups[0].len() <= if grouped { 3 } else { usize::MAX }
+        && groups[1..].iter().all(|g| g.len() == 3);
+    if !valid || !groups.iter().all(|g| g.bytes().all(|b| b.is_ascii_digit())) {
+        return None;
+    }
+    if !grouped && frac.is_none() {
+        return None;
+    }
+    let mut out = groups.join(&conv.group.to_string());
+    if let Some(frac) = frac {
+        out.push(conv.decimal);
+        out.push_str(frac);
+    }
+    Some(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_basic_localizer() {
+        let l = BasicLocalizer;
+        let text = "Order 1042 shipped 2024-03-07, due 03/21/2024. Total: $1,234.50 (tax 3.5%).";
+        assert_eq!(l.localize(text, "es-AR"), "Order 1042 shipped 07/03/2024, due 21/03/2024. Total: $1.234,50 (tax 3,5%).");
+        assert_eq!(l.localize(text, "es-MX"), "Order 1042 shipped 07/03/2024, due 21/03/2024. Total: $1,234.50 (tax 3.5%).");
+        assert_eq!(l.localize(text, "pt-BR,pt;q=0.9"), l.localize(text, "es-CO"));
+        assert_eq!(l.localize(text, "de-DE"), "Order 1042 shipped 07.03.2024, due 21.03.2024. Total: $1.234,50 (tax 3,5%).");
+        assert_eq!(l.localize(text, "en-US"), text);
+        assert_eq!(l.localize(text, "xx"), text);
+        assert_eq!(l.localize("1,234,567.8", "fr-FR"), "1\u{a0}234\u{a0}567,8");
+        assert_eq!(l.localize("2024-03-07", "ja-JP"), "2024/03/07");
+    }
+
+    #[test]
+    fn test_basic_localizer_leaves_non_numbers() {
+        let l = BasicLocalizer;
+        for text in ["v2.5 of 1.2.3", "10.0.0.1", "at 2024-03-07T10:00Z", "12:30", "3.5GB", "1,23,456", "13/45/2024", "id_2.5"] {
+            assert_eq!(l.localize(text, "es-AR"), text);
+        }
+    }
+}
@@ -0,0 +1,270 @@
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::mpsc::{channel, Receiver, Sender};
+use std::sync::{Arc, Mutex};
+
+use serde_json::{json, Value};
+
+use crate::server::{Server, ServerBuilder};
+use crate::types::{parse_request, JsonRpcNotification, JsonRpcResponse, ServerRequest};
+
+/// An in-process transport for tests: each [`MemoryClient`] is a session
+/// whose requests go straight to [`Server::handle`] and whose
+/// notifications and server-to-client requests arrive on a channel.
+///
+/// Every message is serialized and parsed on the way through, as it would
+/// be over HTTP, so tests cover the wire format without binding a socket.
+///
+/// ```rust
+/// # use std::sync::Arc;
+/// # use mcpserver::{MemoryTransport, Server};
+/// # async fn run() {
+/// let transport = MemoryTransport::new();
+/// let server = Arc::new(transport.attach(Server::builder()).build());
+/// let client = transport.connect(&server, serde_json::json!({}));
+/// let init = client.initialize(serde_json::json!({})).await;
+/// assert!(init.error.is_none());
+/// # }
+/// ```
+#[derive(Clone, Default)]
+pub struct MemoryTransport {
+    /// Channel to each connected session's client end.
+    clients: Arc<Mutex<HashMap<String, Sender<Value>>>>,
+    next_session: Arc<AtomicU64>,
+}
+
+impl MemoryTransport {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Route the builder's notifications and server-to-client requests
+    /// through this transport.  Notifications without a session go to
+    /// every connected client.
+    pub fn attach(&self, builder: ServerBuilder) -> ServerBuilder {
+        let clients = self.clients.clone();
+        let requests = self.clients.clone();
+        builder
+            .on_notification(move |n: &JsonRpcNotification| {
+                let clients = clients.lock().unwrap_or_else(|e| e.into_inner());
+                let message = wire(n);
+                match &n.session {
+                    Some(session) => {
+                        if let Some(tx) = clients.get(session) {
+                            let _ = tx.send(message);
+                        }
+                    }
+                    None => clients.values().for_each(|tx| {
+                        let _ = tx.send(message.clone());
+                    }),
+                }
+            })
+            .on_request(move |r: &ServerRequest| {
+                let clients = requests.lock().unwrap_or_else(|e| e.into_inner());
+                if let Some(tx) = clients.get(&r.session) {
+                    let _ = tx.send(wire(r));
+                }
+            })
+    }
+
+    /// Open a new session on `server`.  `context` is passed with every
+    /// request (e.g. JWT claims), with `sessionId` added.
+    pub fn connect(&self, server: &Arc<Server>, context: Value) -> MemoryClient {
+        let n = self.next_session.fetch_add(1, Ordering::Relaxed) + 1;
+        let session = format!("mem-{}", n);
+        let (tx, rx) = channel();
+        self.clients
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .insert(session.clone(), tx);
+
+        let mut context = match context {
+            Value::Object(map) => map,
+            _ => serde_json::Map::new(),
+        };
+        context.insert("sessionId".into(), json!(session));
+        MemoryClient {
+            server: Arc::clone(server),
+            transport: self.clone(),
+            session,
+            context: Value::Object(context),
+            inbox: Mutex::new(rx),
+            next_id: AtomicU64::new(1),
+        }
+    }
+}
+
+/// A message as it appears on the wire.
+fn wire(message: &impl serde::Serialize) -> Value {
+    serde_json::to_value(message).unwrap_or_default()
+}
+
+/// The client end of one [`MemoryTransport`] session.
+pub struct MemoryClient {
+    server: Arc<Server>,
+    transport: MemoryTransport,
+    session: String,
+    context: Value,
+    inbox: Mutex<Receiver<Value>>,
+    next_id: AtomicU64,
+}
+
+impl MemoryClient {
+    /// This client's session ID.
+    pub fn session(&self) -> &str {
+        &self.session
+    }
+
+    /// Send a request with the next numeric ID and return the response.
+    pub async fn request(&self, method: &str, params: Option<Value>) -> JsonRpcResponse {
+        let id = self.next_id.fetch_add(1, Ordering::Relaxed);
+        let mut message = json!({"jsonrpc": "2.0", "id": id, "method": method});
+        if let Some(params) = params {
+            message["params"] = params;
+        }
+        self.send(message).await.unwrap_or_else(|| JsonRpcResponse {
+            jsonrpc: "2.0".into(),
+            id: Some(json!(id)),
+            result: None,
+            error: None,
+        })
+    }
+
+    /// Send a notification (no ID, no response).
+    pub async fn notify(&self, method: &str, params: Option<Value>) {
+        let mut message = json!({"jsonrpc": "2.0", "method": method});
+        if let Some(params) = params {
+            message["params"] = params;
+        }
+        self.send(message).await;
+    }
+
+    /// Send a raw JSON-RPC message, as an HTTP body would carry it.
+    /// Returns `None` when the server sends no response body.
+    pub async fn send(&self, message: Value) -> Option<JsonRpcResponse> {
+        let body = serde_json::to_vec(&message).unwrap_or_default();
+        let req = match parse_request(&body) {
+            Ok(req) => req,
+            Err(resp) => return Some(resp),
+        };
+        let resp = self.server.handle(req, self.context.clone()).await;
+        if resp.is_notification() {
+            return None;
+        }
+        serde_json::from_value(wire(&resp)).ok()
+    }
+
+    /// `initialize` with `capabilities`, then `notifications/initialized`.
+    /// Returns the initialize response.
+    pub async fn initialize(&self, capabilities: Value) -> JsonRpcResponse {
+        let params = json!({
+            "protocolVersion": crate::types::PROTOCOL_VERSION,
+            "capabilities": capabilities,
+            "clientInfo": {"name": "memory-client", "version": "0"},
+        });
+        let resp = self.request("initialize", Some(params)).await;
+        if resp.error.is_none() {
+            self.notify("notifications/initialized", None).await;
+        }
+        resp
+    }
+
+    /// The next notification or server-to-client request addressed to this
+    /// session, if one is waiting.
+    pub fn next_message(&self) -> Option<Value> {
+        self.inbox.lock().unwrap_or_else(|e| e.into_inner()).try_recv().ok()
+    }
+
+    /// Every waiting message, oldest first.
+    pub fn drain(&self) -> Vec<Value> {
+        std::iter::from_fn(|| self.next_message()).collect()
+    }
+
+    /// Answer a server-to-client request.  Returns false if the server was
+    /// not waiting for `id` from this session.
+    pub fn respond(&self, id: Value, result: Value) -> bool {
+        let resp = JsonRpcResponse {
+            jsonrpc: "2.0".into(),
+            id: Some(id),
+            result: Some(result),
+            error: None,
+        };
+        self.server.handle_client_response(&self.session, resp)
+    }
+
+    /// Disconnect and end the session on the server.
+    pub fn close(self) {
+        self.transport
+            .clients
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .remove(&self.session);
+        self.server.end_session(&self.session);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::server::FnToolHandler;
+    use crate::types::text_result;
+
+    const TOOLS: &[u8] = br#"[{"name":"echo","description":"e","inputSchema":{"type":"object"}}]"#;
+
+    fn server(transport: &MemoryTransport) -> Arc<Server> {
+        let mut srv = transport.attach(Server::builder().strict_lifecycle(true)).tools_json(TOOLS).build();
+        srv.handle_tool(
+            "echo",
+            FnToolHandler::new(|args: Value, ctx: Value| async move {
+                Ok(text_result(format!("{} {}", ctx["sessionId"].as_str().unwrap(), args["msg"].as_str().unwrap_or(""))))
+            }),
+        );
+        Arc::new(srv)
+    }
+
+    #[tokio::test]
+    async fn test_sessions_and_notifications() {
+        let transport = MemoryTransport::new();
+        let srv = server(&transport);
+        let a = transport.connect(&srv, json!({"sub": "alice"}));
+        let b = transport.connect(&srv, json!({}));
+
+        // Strict lifecycle: calls before the handshake are rejected.
+        let early = a.request("tools/list", None).await;
+        assert_eq!(early.error.unwrap().code, crate::types::ERR_CODE_INVALID_REQ);
+
+        assert!(a.initialize(json!({})).await.error.is_none());
+        let resp = a.request("tools/call", Some(json!({"name": "echo", "arguments": {"msg": "hi"}}))).await;
+        assert_eq!(resp.result.unwrap()["content"][0]["text"], "mem-1 hi");
+
+        let bad = a.send(json!({"jsonrpc": "2.0", "id": 9})).await.unwrap();
+        assert_eq!(bad.error.unwrap().code, crate::types::ERR_CODE_INVALID_REQ);
+
+        // Catalog changes reach every session.
+        let echo = crate::loader::parse_tools(TOOLS).unwrap().remove(0);
+        srv.remove_tool("echo").unwrap();
+        srv.add_tool(echo).unwrap();
+        assert_eq!(a.drain().len(), 2);
+        assert_eq!(b.next_message().unwrap()["method"], "notifications/tools/list_changed");
+
+        b.close();
+        srv.remove_tool("echo").unwrap();
+        assert_eq!(a.drain().len(), 1);
+    }
+
+    #[tokio::test]
+    async fn test_server_to_client_requests() {
+        let transport = MemoryTransport::new();
+        let srv = server(&transport);
+        let client = transport.connect(&srv, json!({}));
+        client.initialize(json!({"roots": {}})).await;
+
+        let (result, answered) = tokio::join!(srv.request(client.session(), "roots/list", None), async {
+            let req = client.next_message().unwrap();
+            assert_eq!(req["method"], "roots/list");
+            client.respond(req["id"].clone(), json!({"roots": []}))
+        });
+        assert!(answered);
+        assert_eq!(result.unwrap(), json!({"roots": []}));
+    }
+}
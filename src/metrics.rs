@@ -0,0 +1,199 @@
+use std::sync::Arc;
+use std::time::{Duration, SystemTime};
+
+use serde_json::json;
+
+use crate::sampling::RequestSample;
+use crate::types::Tool;
+
+/// Receives per-call measurements from the server.
+pub trait MetricsSink: Send + Sync {
+    /// One `tools/call` finished.  `is_error` is true for error results,
+    /// including results replaced by execution limits or output filters.
+    fn tool_call(&self, tool: &str, elapsed: Duration, is_error: bool);
+
+    /// The same measurement with the whole tool, for sinks that label by
+    /// [`category`](Tool::category) or [`tags`](Tool::tags).  This is what
+    /// the server calls; the default forwards to
+    /// [`tool_call`](Self::tool_call).
+    fn tool_call_labeled(&self, tool: &Tool, elapsed: Duration, is_error: bool) {
+        self.tool_call(&tool.name, elapsed, is_error);
+    }
+
+    /// A request kept by the server's [`Sampling`](crate::Sampling) policy,
+    /// for sinks that attach exemplars (a trace or request id next to a
+    /// latency point).  Called after the request's other measurements.
+    /// The default ignores it.
+    fn exemplar(&self, _sample: &RequestSample) {}
+
+    /// A `tools/call` was abandoned before its handler finished: the client
+    /// disconnected or sent `notifications/cancelled`.  Not counted by
+    /// [`tool_call`](Self::tool_call).  The default ignores it.
+    fn tool_cancelled(&self, _tool: &Tool, _elapsed: Duration) {}
+}
+
+/// Writes one CloudWatch Embedded Metric Format (EMF) line per tool call.
+///
+/// In Lambda, lines written to stdout become CloudWatch metrics with no
+/// API calls or sidecar.  Each line carries `Latency` (milliseconds) and
+/// `Errors` and `Cancelled` (counts) with a `Tool` dimension, plus a
+/// `Category` dimension and a `Tags` property for tools that declare them.
+pub struct EmfSink {
+    namespace: String,
+    write: Arc<dyn Fn(&str) + Send + Sync>,
+}
+
+impl EmfSink {
+    /// Emit to stdout under the given CloudWatch namespace.
+    pub fn new(namespace: impl Into<String>) -> Self {
+        Self::with_writer(namespace, |line| println!("{}", line))
+    }
+
+    /// Emit through a custom writer (e.g. a log file or a test buffer).
+    pub fn with_writer(
+        namespace: impl Into<String>,
+        write: impl Fn(&str) + Send + Sync + 'static,
+    ) -> Self {
+        EmfSink {
+            namespace: namespace.into(),
+            write: Arc::new(write),
+        }
+    }
+
+    fn line(&self, tool: &str, elapsed: Duration, is_error: bool, timestamp: SystemTime) -> String {
+        self.labeled_line(tool, None, &[], elapsed, Outcome::from(is_error), timestamp)
+    }
+
+    fn labeled_line(
+        &self,
+        tool: &str,
+        category: Option<&str>,
+        tags: &[&str],
+        elapsed: Duration,
+        outcome: Outcome,
+        timestamp: SystemTime,
+    ) -> String {
+        let millis = timestamp
+            .duration_since(SystemTime::UNIX_EPOCH)
+            .map(|d| d.as_millis() as u64)
+            .unwrap_or(0);
+        let dimensions = match category {
+            Some(_) => json!([["Tool"], ["Category"]]),
+            None => json!([["Tool"]]),
+        };
+        let mut line = json!({
+            "_aws": {
+                "Timestamp": millis,
+                "CloudWatchMetrics": [{
+                    "Namespace": self.namespace,
+                    "Dimensions": dimensions,
+                    "Metrics": [
+                        {"Name": "Latency", "Unit": "Milliseconds"},
+                        {"Name": "Errors", "Unit": "Count"},
+                        {"Name": "Cancelled", "Unit": "Count"},
+                    ],
+                }],
+            },
+            "Tool": tool,
+            "Latency": elapsed.as_secs_f64() * 1000.0,
+            "Errors": (outcome == Outcome::Error) as u32,
+            "Cancelled": (outcome == Outcome::Cancelled) as u32,
+        });
+        if let Some(category) = category {
+            line["Category"] = json!(category);
+        }
+        if !tags.is_empty() {
+            line["Tags"] = json!(tags);
+        }
+        line.to_string()
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Outcome {
+    Ok,
+    Error,
+    Cancelled,
+}
+
+impl From<bool> for Outcome {
+    fn from(is_error: bool) -> Self {
+        if is_error { Outcome::Error } else { Outcome::Ok }
+    }
+}
+
+impl MetricsSink for EmfSink {
+    fn tool_call(&self, tool: &str, elapsed: Duration, is_error: bool) {
+        (self.write)(&self.line(tool, elapsed, is_error, SystemTime::now()));
+    }
+
+    fn tool_call_labeled(&self, tool: &Tool, elapsed: Duration, is_error: bool) {
+        let line = self.labeled_line(
+            &tool.name,
+            tool.category(),
+            &tool.tags(),
+            elapsed,
+            Outcome::from(is_error),
+            SystemTime::now(),
+        );
+        (self.write)(&line);
+    }
+
+    fn tool_cancelled(&self, tool: &Tool, elapsed: Duration) {
+        let line = self.labeled_line(
+            &tool.name,
+            tool.category(),
+            &tool.tags(),
+            elapsed,
+            Outcome::Cancelled,
+            SystemTime::now(),
+        );
+        (self.write)(&line);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    #[test]
+    fn test_emf_line_shape() {
+        let lines = Arc::new(Mutex::new(Vec::new()));
+        let sink = Arc::clone(&lines);
+        let emf = EmfSink::with_writer("MCP", move |l| sink.lock().unwrap().push(l.to_string()));
+        emf.tool_call("geocode", Duration::from_millis(12), true);
+
+        let lines = lines.lock().unwrap();
+        let v: serde_json::Value = serde_json::from_str(&lines[0]).unwrap();
+        assert_eq!(v["_aws"]["CloudWatchMetrics"][0]["Namespace"], "MCP");
+        assert_eq!(v["_aws"]["CloudWatchMetrics"][0]["Dimensions"], json!([["Tool"]]));
+        assert!(v["_aws"]["Timestamp"].as_u64().unwrap() > 0);
+        assert_eq!(v["Tool"], "geocode");
+        assert_eq!(v["Latency"], 12.0);
+        assert_eq!(v["Errors"], 1);
+        assert_eq!(v["Cancelled"], 0);
+        assert!(v.get("Category").is_none());
+    }
+
+    #[test]
+    fn test_emf_line_labels() {
+        let lines = Arc::new(Mutex::new(Vec::new()));
+        let sink = Arc::clone(&lines);
+        let emf = EmfSink::with_writer("MCP", move |l| sink.lock().unwrap().push(l.to_string()));
+        let tools = crate::loader::parse_tools(
+            br#"[{"name":"refund","description":"r","inputSchema":{"type":"object"},"category":"billing","tags":["write"]}]"#,
+        )
+        .unwrap();
+        emf.tool_call_labeled(&tools[0], Duration::from_millis(3), false);
+
+        let v: serde_json::Value = serde_json::from_str(&lines.lock().unwrap()[0]).unwrap();
+        assert_eq!(v["_aws"]["CloudWatchMetrics"][0]["Dimensions"], json!([["Tool"], ["Category"]]));
+        assert_eq!(v["Category"], "billing");
+        assert_eq!(v["Tags"], json!(["write"]));
+
+        emf.tool_cancelled(&tools[0], Duration::from_millis(40));
+        let v: serde_json::Value = serde_json::from_str(&lines.lock().unwrap()[1]).unwrap();
+        assert_eq!((v["Errors"].as_u64(), v["Cancelled"].as_u64()), (Some(0), Some(1)));
+    }
+}
@@ -0,0 +1,203 @@
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::task::{Poll, Waker};
+use std::time::{Duration, Instant};
+
+use serde_json::Value;
+
+use crate::types::{JsonRpcResponse, McpError};
+
+/// One server-to-client request waiting for its response.
+struct Slot {
+    outcome: Option<Result<Value, McpError>>,
+    waker: Option<Waker>,
+    sent_at: Instant,
+}
+
+impl Slot {
+    fn complete(&mut self, outcome: Result<Value, McpError>) {
+        self.outcome = Some(outcome);
+        if let Some(waker) = self.waker.take() {
+            waker.wake();
+        }
+    }
+}
+
+type Key = (String, u64);
+
+/// Server-to-client requests awaiting a response, keyed by (session,
+/// request ID) so a client can only answer requests sent to it.
+#[derive(Default)]
+pub(crate) struct Outbound {
+    next_id: AtomicU64,
+    pending: Mutex<HashMap<Key, Arc<Mutex<Slot>>>>,
+}
+
+impl Outbound {
+    /// Allocate an ID and track the request until the guard is dropped.
+    pub fn register(&self, session: &str, now: Instant) -> Pending<'_> {
+        let id = self.next_id.fetch_add(1, Ordering::Relaxed) + 1;
+        let slot = Arc::new(Mutex::new(Slot {
+            outcome: None,
+            waker: None,
+            sent_at: now,
+        }));
+        let key = (session.to_string(), id);
+        self.lock().insert(key.clone(), Arc::clone(&slot));
+        Pending {
+            registry: self,
+            key,
+            slot,
+        }
+    }
+
+    /// Complete the pending request `response` answers.  Returns false if
+    /// the ID is unknown for this session, or already answered.
+    pub fn resolve(&self, session: &str, response: JsonRpcResponse) -> bool {
+        let Some(id) = response.id.as_ref().and_then(|v| v.as_u64()) else {
+            return false;
+        };
+        let Some(slot) = self.lock().remove(&(session.to_string(), id)) else {
+            return false;
+        };
+        let outcome = match response.error {
+            Some(e) => Err(McpError::Other(format!("client error {}: {}", e.code, e.message))),
+            None => Ok(response.result.unwrap_or(Value::Null)),
+        };
+        lock_slot(&slot).complete(outcome);
+        true
+    }
+
+    /// Fail every request older than `timeout`.  Returns how many expired.
+    pub fn expire(&self, now: Instant, timeout: Duration) -> usize {
+        self.fail_where(
+            |_, slot| now.saturating_duration_since(slot.sent_at) >= timeout,
+            || McpError::Other(format!("client did not respond within {:?}", timeout)),
+        )
+    }
+
+    /// Fail every request sent to `session` (e.g. when it ends).
+    pub fn fail_session(&self, session: &str) {
+        self.fail_where(|key, _| key.0 == session, || McpError::Other("session ended".into()));
+    }
+
+    fn fail_where(&self, matches: impl Fn(&Key, &Slot) -> bool, error: impl Fn() -> McpError) -> usize {
+        let mut pending = self.lock();
+        let keys: Vec<Key> = pending
+            .iter()
+            .filter(|(key, slot)| matches(key, &lock_slot(slot)))
+            .map(|(key, _)| key.clone())
+            .collect();
+        for key in &keys {
+            if let Some(slot) = pending.remove(key) {
+                lock_slot(&slot).complete(Err(error()));
+            }
+        }
+        keys.len()
+    }
+
+    #[cfg(test)]
+    pub fn is_empty(&self) -> bool {
+        self.lock().is_empty()
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, HashMap<Key, Arc<Mutex<Slot>>>> {
+        self.pending.lock().unwrap_or_else(|e| e.into_inner())
+    }
+}
+
+fn lock_slot(slot: &Mutex<Slot>) -> std::sync::MutexGuard<'_, Slot> {
+    slot.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// A registered request.  Await [`response`](Self::response); dropping it
+/// early (e.g. under a runtime timeout) forgets the request.
+pub(crate) struct Pending<'a> {
+    registry: &'a Outbound,
+    key: Key,
+    slot: Arc<Mutex<Slot>>,
+}
+
+impl Pending<'_> {
+    pub fn id(&self) -> u64 {
+        self.key.1
+    }
+
+    pub async fn response(&self) -> Result<Value, McpError> {
+        std::future::poll_fn(|cx| {
+            let mut slot = lock_slot(&self.slot);
+            match slot.outcome.take() {
+                Some(outcome) => Poll::Ready(outcome),
+                None => {
+                    slot.waker = Some(cx.waker().clone());
+                    Poll::Pending
+                }
+            }
+        })
+        .await
+    }
+}
+
+impl Drop for Pending<'_> {
+    fn drop(&mut self) {
+        let mut pending = self.registry.lock();
+        if pending.get(&self.key).is_some_and(|s| Arc::ptr_eq(s, &self.slot)) {
+            pending.remove(&self.key);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::RpcError;
+    use serde_json::json;
+
+    fn response(id: Value, result: Option<Value>, error: Option<RpcError>) -> JsonRpcResponse {
+        JsonRpcResponse {
+            jsonrpc: "2.0".into(),
+            id: Some(id),
+            result,
+            error,
+        }
+    }
+
+    #[tokio::test]
+    async fn test_response_is_matched_by_session_and_id() {
+        let outbound = Outbound::default();
+        let pending = outbound.register("s1", Instant::now());
+        let id = json!(pending.id());
+
+        assert!(!outbound.resolve("s2", response(id.clone(), Some(json!({})), None)));
+        assert!(outbound.resolve("s1", response(id.clone(), Some(json!({"roots": []})), None)));
+        assert!(!outbound.resolve("s1", response(id, Some(json!({})), None)));
+        assert_eq!(pending.response().await.unwrap(), json!({"roots": []}));
+    }
+
+    #[tokio::test]
+    async fn test_client_error_expiry_and_drop() {
+        let outbound = Outbound::default();
+        let pending = outbound.register("s1", Instant::now());
+        let error = RpcError {
+            code: -1,
+            message: "user rejected".into(),
+            data: None,
+        };
+        outbound.resolve("s1", response(json!(pending.id()), None, Some(error)));
+        assert!(pending.response().await.unwrap_err().to_string().contains("user rejected"));
+
+        let start = Instant::now();
+        let old = outbound.register("s1", start);
+        let fresh = outbound.register("s1", start + Duration::from_secs(20));
+        assert_eq!(outbound.expire(start + Duration::from_secs(30), Duration::from_secs(30)), 1);
+        assert!(old.response().await.unwrap_err().to_string().contains("did not respond"));
+
+        outbound.fail_session("s1");
+        assert!(fresh.response().await.is_err());
+
+        drop(outbound.register("s2", start));
+        drop((old, fresh, pending));
+        assert!(outbound.is_empty());
+    }
+}
@@ -0,0 +1,182 @@
+use serde_json::Value;
+
+use crate::types::McpError;
+
+/// Apply an RFC 6902 JSON Patch (`add`, `remove`, `replace`, `move`,
+/// `copy`, `test`) to `doc`.  The patch is atomic: if any operation fails,
+/// `doc` is left unchanged and the error names the failing operation.
+pub fn apply_patch(doc: &mut Value, patch: &Value) -> Result<(), McpError> {
+    let ops = patch
+        .as_array()
+        .ok_or_else(|| McpError::Validation("patch must be an array of operations".into()))?;
+    let mut next = doc.clone();
+    for (i, op) in ops.iter().enumerate() {
+        apply_op(&mut next, op)
+            .map_err(|e| McpError::Validation(format!("patch op {}: {}", i, e)))?;
+    }
+    *doc = next;
+    Ok(())
+}
+
+fn apply_op(doc: &mut Value, op: &Value) -> Result<(), String> {
+    let field = |name: &str| {
+        op.get(name)
+            .and_then(|v| v.as_str())
+            .ok_or_else(|| format!("missing \"{}\"", name))
+    };
+    let value = || op.get("value").cloned().ok_or_else(|| "missing \"value\"".to_string());
+    let path = parse_pointer(field("path")?)?;
+
+    match field("op")? {
+        "add" => add(doc, &path, value()?),
+        "remove" => remove(doc, &path).map(drop),
+        "replace" => {
+            let target = get_mut(doc, &path).ok_or("path does not exist")?;
+            *target = value()?;
+            Ok(())
+        }
+        "move" => {
+            let from = parse_pointer(field("from")?)?;
+            if path.len() > from.len() && path[..from.len()] == from[..] {
+                return Err("cannot move a value into itself".into());
+            }
+            let moved = remove(doc, &from)?;
+            add(doc, &path, moved)
+        }
+        "copy" => {
+            let from = parse_pointer(field("from")?)?;
+            let copied = get(doc, &from).ok_or("from does not exist")?.clone();
+            add(doc, &path, copied)
+        }
+        "test" => match get(doc, &path) {
+            Some(actual) if *actual == value()? => Ok(()),
+            _ => Err("test failed".into()),
+        },
+        other => Err(format!("unknown op {}", other)),
+    }
+}
+
+/// Split an RFC 6901 JSON Pointer into unescaped reference tokens.
+fn parse_pointer(pointer: &str) -> Result<Vec<String>, String> {
+    if pointer.is_empty() {
+        return Ok(Vec::new());
+    }
+    let rest = pointer
+        .strip_prefix('/')
+        .ok_or_else(|| format!("invalid pointer {}", pointer))?;
+    Ok(rest
+        .split('/')
+        .map(|t| t.replace("~1", "/").replace("~0", "~"))
+        .collect())
+}
+
+/// An array index token: `0` or digits without a leading zero.
+fn index(token: &str) -> Option<usize> {
+    let digits = !token.is_empty() && token.bytes().all(|b| b.is_ascii_digit());
+    if !digits || (token.len() > 1 && token.starts_with('0')) {
+        return None;
+    }
+    token.parse().ok()
+}
+
+fn get<'a>(doc: &'a Value, path: &[String]) -> Option<&'a Value> {
+    path.iter().try_fold(doc, |v, token| match v {
+        Value::Object(map) => map.get(token),
+        Value::Array(items) => items.get(index(token)?),
+        _ => None,
+    })
+}
+
+fn get_mut<'a>(doc: &'a mut Value, path: &[String]) -> Option<&'a mut Value> {
+    path.iter().try_fold(doc, |v, token| match v {
+        Value::Object(map) => map.get_mut(token),
+        Value::Array(items) => items.get_mut(index(token)?),
+        _ => None,
+    })
+}
+
+fn add(doc: &mut Value, path: &[String], value: Value) -> Result<(), String> {
+    let Some((last, parent)) = path.split_last() else {
+        *doc = value;
+        return Ok(());
+    };
+    match get_mut(doc, parent).ok_or("parent does not exist")? {
+        Value::Object(map) => {
+            map.insert(last.clone(), value);
+            Ok(())
+        }
+        Value::Array(items) if last == "-" => {
+            items.push(value);
+            Ok(())
+        }
+        Value::Array(items) => match index(last) {
+            Some(i) if i <= items.len() => {
+                items.insert(i, value);
+                Ok(())
+            }
+            _ => Err(format!("invalid array index {}", last)),
+        },
+        _ => Err("parent is not an object or array".into()),
+    }
+}
+
+fn remove(doc: &mut Value, path: &[String]) -> Result<Value, String> {
+    let (last, parent) = path.split_last().ok_or("cannot remove the whole document")?;
+    match get_mut(doc, parent).ok_or("parent does not exist")? {
+        Value::Object(map) => map.remove(last).ok_or_else(|| "path does not exist".into()),
+        Value::Array(items) => match index(last) {
+            Some(i) if i < items.len() => Ok(items.remove(i)),
+            _ => Err(format!("invalid array index {}", last)),
+        },
+        _ => Err("parent is not an object or array".into()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_apply_patch_ops() {
+        let mut doc = json!({"tools": {"a/b": {"description": "old", "tags": ["x"]}}, "n": 1});
+        let patch = json!([
+            {"op": "test", "path": "/n", "value": 1},
+            {"op": "replace", "path": "/tools/a~1b/description", "value": "new"},
+            {"op": "add", "path": "/tools/a~1b/tags/-", "value": "y"},
+            {"op": "add", "path": "/tools/a~1b/tags/0", "value": "w"},
+            {"op": "copy", "from": "/tools/a~1b", "path": "/tools/c"},
+            {"op": "move", "from": "/n", "path": "/m"},
+            {"op": "remove", "path": "/tools/c/tags/1"},
+        ]);
+        apply_patch(&mut doc, &patch).unwrap();
+        assert_eq!(
+            doc,
+            json!({
+                "tools": {
+                    "a/b": {"description": "new", "tags": ["w", "x", "y"]},
+                    "c": {"description": "new", "tags": ["w", "y"]},
+                },
+                "m": 1,
+            })
+        );
+    }
+
+    #[test]
+    fn test_apply_patch_is_atomic() {
+        let mut doc = json!({"a": 1, "list": [1]});
+        for patch in [
+            json!([{"op": "replace", "path": "/a", "value": 2}, {"op": "test", "path": "/a", "value": 1}]),
+            json!([{"op": "remove", "path": "/missing"}]),
+            json!([{"op": "add", "path": "/list/5", "value": 0}]),
+            json!([{"op": "add", "path": "/list/01", "value": 0}]),
+            json!([{"op": "move", "from": "/list", "path": "/list/0"}]),
+            json!([{"op": "frobnicate", "path": "/a"}]),
+            json!({"op": "remove", "path": "/a"}),
+        ] {
+            let err = apply_patch(&mut doc, &patch).unwrap_err();
+            assert!(matches!(err, McpError::Validation(_)), "{patch}");
+        }
+        assert_eq!(doc, json!({"a": 1, "list": [1]}));
+    }
+}
@@ -0,0 +1,119 @@
+use serde::{Deserialize, Serialize};
+use serde_json::{Map, Value};
+
+use crate::types::ToolResult;
+
+/// One step of a composite tool: the tool to call and its argument
+/// mapping.
+///
+/// String values of the form `$args.path` or `$steps.N.path` are replaced
+/// by the referenced JSON value (the composite call's arguments, or the
+/// output of an earlier step); everything else is passed through as-is.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Step {
+    pub tool: String,
+    #[serde(default)]
+    pub arguments: Value,
+}
+
+/// Build a step's arguments from its mapping.  An object key whose
+/// reference resolves to nothing is left out, so the step tool's
+/// `required` check reports it; elsewhere a missing value becomes `null`.
+pub(crate) fn resolve(mapping: &Value, args: &Value, outputs: &[Value]) -> Value {
+    resolve_opt(mapping, args, outputs).unwrap_or(Value::Null)
+}
+
+fn resolve_opt(mapping: &Value, args: &Value, outputs: &[Value]) -> Option<Value> {
+    match mapping {
+        Value::String(s) => match reference(s, args, outputs) {
+            Some(resolved) => resolved,
+            None => Some(mapping.clone()),
+        },
+        Value::Object(map) => Some(Value::Object(
+            map.iter()
+                .filter_map(|(k, v)| resolve_opt(v, args, outputs).map(|v| (k.clone(), v)))
+                .collect::<Map<_, _>>(),
+        )),
+        Value::Array(items) => Some(Value::Array(
+            items.iter().map(|v| resolve(v, args, outputs)).collect(),
+        )),
+        other => Some(other.clone()),
+    }
+}
+
+/// `None` when `s` is not a reference; `Some(None)` when it is one but
+/// points at nothing.
+fn reference(s: &str, args: &Value, outputs: &[Value]) -> Option<Option<Value>> {
+    let path = s.strip_prefix('$')?;
+    let mut segments = path.split('.');
+    let mut value = match segments.next()? {
+        "args" => Some(args),
+        "steps" => outputs.get(segments.next()?.parse::<usize>().ok()?),
+        _ => return None,
+    };
+    for seg in segments {
+        value = value.and_then(|v| match v {
+            Value::Array(items) => seg.parse::<usize>().ok().and_then(|i| items.get(i)),
+            other => other.get(seg),
+        });
+    }
+    Some(value.cloned())
+}
+
+/// The value later steps see for a step's result: its `structuredContent`
+/// when present, otherwise its first text block, parsed as JSON when
+/// possible.
+pub(crate) fn output_value(result: &ToolResult) -> Value {
+    if let Some(v) = &result.structured_content {
+        return v.clone();
+    }
+    let text = result
+        .content
+        .iter()
+        .find_map(|b| b.text.as_deref())
+        .unwrap_or_default();
+    serde_json::from_str(text).unwrap_or_else(|_| Value::String(text.to_string()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{structured_result, text_result};
+    use serde_json::json;
+
+    #[test]
+    fn test_resolve_references() {
+        let args = json!({"channel": "general", "user": {"id": 7}});
+        let outputs = vec![json!({"subscriptionId": "sub-1", "tags": ["a", "b"]})];
+        let mapping = json!({
+            "channel": "$args.channel",
+            "userId": "$args.user.id",
+            "subscription": "$steps.0.subscriptionId",
+            "firstTag": "$steps.0.tags.0",
+            "missing": "$steps.3.x",
+            "literal": "hello",
+            "price": "$5",
+            "nested": ["$args.channel", 1],
+        });
+        assert_eq!(
+            resolve(&mapping, &args, &outputs),
+            json!({
+                "channel": "general",
+                "userId": 7,
+                "subscription": "sub-1",
+                "firstTag": "a",
+                "literal": "hello",
+                "price": "$5",
+                "nested": ["general", 1],
+            })
+        );
+        assert_eq!(resolve(&json!("$args.nope"), &args, &outputs), Value::Null);
+    }
+
+    #[test]
+    fn test_output_value() {
+        assert_eq!(output_value(&structured_result(json!({"a": 1}))), json!({"a": 1}));
+        assert_eq!(output_value(&text_result(r#"{"b": 2}"#)), json!({"b": 2}));
+        assert_eq!(output_value(&text_result("plain")), json!("plain"));
+    }
+}
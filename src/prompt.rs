@@ -0,0 +1,117 @@
+use std::collections::HashMap;
+
+use serde_json::Value;
+
+use crate::types::{Prompt, PromptMessage};
+
+impl Prompt {
+    /// Resolve raw prompts/get arguments against the declared arguments.
+    ///
+    /// Fails when a required argument is missing.  Non-string values are
+    /// rendered as JSON text; undeclared arguments are passed through.
+    pub fn resolve_arguments(
+        &self,
+        args: &serde_json::Map<String, Value>,
+    ) -> Result<HashMap<String, String>, String> {
+        for arg in &self.arguments {
+            if arg.required && !args.contains_key(&arg.name) {
+                return Err(format!("missing required argument \"{}\"", arg.name));
+            }
+        }
+
+        Ok(args
+            .iter()
+            .map(|(k, v)| {
+                let text = match v {
+                    Value::String(s) => s.clone(),
+                    other => other.to_string(),
+                };
+                (k.clone(), text)
+            })
+            .collect())
+    }
+
+    /// Render the message templates, substituting `{{name}}` placeholders.
+    ///
+    /// Declared arguments that were not supplied render as empty strings;
+    /// placeholders that do not name a declared or supplied argument are
+    /// left untouched so typos stay visible.
+    pub fn render(&self, args: &HashMap<String, String>) -> Vec<PromptMessage> {
+        self.messages
+            .iter()
+            .map(|msg| {
+                let mut msg = msg.clone();
+                if let Some(text) = &msg.content.text {
+                    msg.content.text = Some(self.substitute(text, args));
+                }
+                msg
+            })
+            .collect()
+    }
+
+    fn substitute(&self, template: &str, args: &HashMap<String, String>) -> String {
+        let mut out = String::with_capacity(template.len());
+        let mut rest = template;
+        while let Some(start) = rest.find("{{") {
+            out.push_str(&rest[..start]);
+            let after = &rest[start + 2..];
+            let Some(end) = after.find("}}") else {
+                out.push_str(&rest[start..]);
+                return out;
+            };
+            let key = after[..end].trim();
+            match args.get(key) {
+                Some(value) => out.push_str(value),
+                None if self.arguments.iter().any(|a| a.name == key) => {}
+                None => out.push_str(&rest[start..start + 2 + end + 2]),
+            }
+            rest = &after[end + 2..];
+        }
+        out.push_str(rest);
+        out
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::loader::parse_prompts;
+
+    fn make_prompt() -> Prompt {
+        let json = r#"[{"name":"summarize","description":"s",
+            "arguments":[{"name":"customer_id","required":true},{"name":"tone"}],
+            "messages":[{"role":"user","content":{"type":"text","text":"Summarize {{customer_id}} in a {{ tone }} tone. {{unknown}}"}}]}]"#;
+        parse_prompts(json.as_bytes()).unwrap().remove(0)
+    }
+
+    #[test]
+    fn test_resolve_missing_required() {
+        let prompt = make_prompt();
+        let err = prompt.resolve_arguments(&serde_json::Map::new()).unwrap_err();
+        assert!(err.contains("customer_id"));
+    }
+
+    #[test]
+    fn test_render_substitutes() {
+        let prompt = make_prompt();
+        let args = serde_json::json!({"customer_id": 42, "tone": "friendly"});
+        let resolved = prompt.resolve_arguments(args.as_object().unwrap()).unwrap();
+        let messages = prompt.render(&resolved);
+        assert_eq!(
+            messages[0].content.text.as_deref(),
+            Some("Summarize 42 in a friendly tone. {{unknown}}")
+        );
+    }
+
+    #[test]
+    fn test_render_optional_missing_is_empty() {
+        let prompt = make_prompt();
+        let args = serde_json::json!({"customer_id": "c-1"});
+        let resolved = prompt.resolve_arguments(args.as_object().unwrap()).unwrap();
+        let messages = prompt.render(&resolved);
+        assert_eq!(
+            messages[0].content.text.as_deref(),
+            Some("Summarize c-1 in a  tone. {{unknown}}")
+        );
+    }
+}
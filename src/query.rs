@@ -0,0 +1,268 @@
+use serde_json::{Map, Value};
+
+use crate::render::{parse_csv, write_row};
+use crate::types::{McpError, ResourceContent};
+
+/// Answers the `query` parameter of `resources/read` over handler
+/// content, so agents pull only the rows and columns they need.
+///
+/// Errors of kind [`McpError::Validation`] are reported to the client as
+/// invalid params.
+pub trait QueryEngine: Send + Sync {
+    fn query(&self, content: ResourceContent, query: &Value) -> Result<ResourceContent, McpError>;
+}
+
+/// Built-in engine for `text/csv` and JSON-array content.
+///
+/// The query selects columns and filters rows:
+///
+/// ```json
+/// {"columns": ["region", "total"], "where": {"region": "EU", "total": {"gte": 100}}}
+/// ```
+///
+/// A bare value tests equality; an object applies `eq`, `ne`, `gt`,
+/// `gte`, `lt`, `lte` or `contains` to the cell.  Comparisons are numeric
+/// when both sides parse as numbers, so CSV cells compare like JSON ones.
+/// The result keeps the content's format.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct TableQuery;
+
+impl QueryEngine for TableQuery {
+    fn query(&self, content: ResourceContent, query: &Value) -> Result<ResourceContent, McpError> {
+        let query = query
+            .as_object()
+            .ok_or_else(|| McpError::Validation("query must be an object".into()))?;
+        let columns: Option<Vec<&str>> = match query.get("columns") {
+            None => None,
+            Some(Value::Array(cols)) => Some(
+                cols.iter()
+                    .map(|c| c.as_str().ok_or_else(|| invalid("columns must be strings")))
+                    .collect::<Result<_, _>>()?,
+            ),
+            Some(_) => return Err(invalid("columns must be an array")),
+        };
+        let conditions = match query.get("where") {
+            None => Vec::new(),
+            Some(Value::Object(map)) => map
+                .iter()
+                .map(|(column, test)| Condition::parse(column, test))
+                .collect::<Result<_, _>>()?,
+            Some(_) => return Err(invalid("where must be an object")),
+        };
+
+        let mime = content.mime_type.as_deref().unwrap_or_default();
+        let essence = mime.split(';').next().unwrap_or_default().trim();
+        let text = content.text.as_deref().unwrap_or_default();
+        let rendered = match essence {
+            "text/csv" => query_csv(text, columns.as_deref(), &conditions)?,
+            "application/json" => query_json(text, columns.as_deref(), &conditions)?,
+            _ => return Err(invalid(&format!("cannot query {} content", mime))),
+        };
+        Ok(ResourceContent {
+            text: Some(rendered),
+            ..content
+        })
+    }
+}
+
+fn invalid(message: &str) -> McpError {
+    McpError::Validation(format!("invalid query: {}", message))
+}
+
+fn query_csv(
+    text: &str,
+    columns: Option<&[&str]>,
+    conditions: &[Condition],
+) -> Result<String, McpError> {
+    let mut rows = parse_csv(text)?.into_iter();
+    let header = rows.next().unwrap_or_default();
+    let index = |name: &str| {
+        header
+            .iter()
+            .position(|h| h == name)
+            .ok_or_else(|| invalid(&format!("unknown column {}", name)))
+    };
+    let selected: Vec<usize> = match columns {
+        Some(cols) => cols.iter().map(|c| index(c)).collect::<Result<_, _>>()?,
+        None => (0..header.len()).collect(),
+    };
+    let tests: Vec<(usize, &Condition)> = conditions
+        .iter()
+        .map(|c| Ok((index(&c.column)?, c)))
+        .collect::<Result<_, McpError>>()?;
+
+    let mut out = String::new();
+    write_row(&mut out, selected.iter().map(|&i| header[i].clone()));
+    for row in rows {
+        let cell = |i: usize| Value::String(row.get(i).cloned().unwrap_or_default());
+        if tests.iter().all(|(i, c)| c.matches(&cell(*i))) {
+            write_row(&mut out, selected.iter().map(|&i| row.get(i).cloned().unwrap_or_default()));
+        }
+    }
+    Ok(out)
+}
+
+fn query_json(
+    text: &str,
+    columns: Option<&[&str]>,
+    conditions: &[Condition],
+) -> Result<String, McpError> {
+    let value: Value = serde_json::from_str(text)?;
+    let Value::Array(records) = value else {
+        return Err(invalid("content is not a JSON array"));
+    };
+    let matched: Vec<Value> = records
+        .into_iter()
+        .filter(|record| {
+            conditions
+                .iter()
+                .all(|c| c.matches(record.get(&c.column).unwrap_or(&Value::Null)))
+        })
+        .map(|record| match (columns, record) {
+            (Some(cols), Value::Object(mut fields)) => {
+                let picked: Map<String, Value> = cols
+                    .iter()
+                    .filter_map(|c| fields.remove(*c).map(|v| (c.to_string(), v)))
+                    .collect();
+                Value::Object(picked)
+            }
+            (_, record) => record,
+        })
+        .collect();
+    Ok(Value::Array(matched).to_string())
+}
+
+/// One `where` entry: `column op operand`.
+struct Condition {
+    column: String,
+    op: Op,
+    operand: Value,
+}
+
+#[derive(Clone, Copy)]
+enum Op {
+    Eq,
+    Ne,
+    Gt,
+    Gte,
+    Lt,
+    Lte,
+    Contains,
+}
+
+impl Condition {
+    fn parse(column: &str, test: &Value) -> Result<Self, McpError> {
+        let (op, operand) = match test {
+            Value::Object(map) if map.len() == 1 => {
+                let (name, operand) = map.iter().next().expect("len checked");
+                let op = match name.as_str() {
+                    "eq" => Op::Eq,
+                    "ne" => Op::Ne,
+                    "gt" => Op::Gt,
+                    "gte" => Op::Gte,
+                    "lt" => Op::Lt,
+                    "lte" => Op::Lte,
+                    "contains" => Op::Contains,
+                    other => return Err(invalid(&format!("unknown operator {}", other))),
+                };
+                (op, operand.clone())
+            }
+            Value::Object(_) => {
+                return Err(invalid(&format!("{}: one operator per column", column)))
+            }
+            value => (Op::Eq, value.clone()),
+        };
+        Ok(Condition {
+            column: column.to_string(),
+            op,
+            operand,
+        })
+    }
+
+    fn matches(&self, cell: &Value) -> bool {
+        use std::cmp::Ordering::*;
+        let ordering = match (number(cell), number(&self.operand)) {
+            (Some(a), Some(b)) => a.partial_cmp(&b),
+            _ => match (cell, &self.operand) {
+                (Value::String(a), Value::String(b)) => Some(a.cmp(b)),
+                (a, b) => (a == b).then_some(Equal),
+            },
+        };
+        match self.op {
+            Op::Eq => ordering == Some(Equal),
+            Op::Ne => ordering != Some(Equal),
+            Op::Gt => ordering == Some(Greater),
+            Op::Gte => matches!(ordering, Some(Greater | Equal)),
+            Op::Lt => ordering == Some(Less),
+            Op::Lte => matches!(ordering, Some(Less | Equal)),
+            Op::Contains => match (cell, &self.operand) {
+                (Value::String(s), Value::String(needle)) => s.contains(needle.as_str()),
+                (Value::Array(items), needle) => items.contains(needle),
+                _ => false,
+            },
+        }
+    }
+}
+
+/// A JSON number, or a string that parses as one.
+fn number(v: &Value) -> Option<f64> {
+    match v {
+        Value::Number(n) => n.as_f64(),
+        Value::String(s) => s.trim().parse().ok(),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn content(mime: &str, text: &str) -> ResourceContent {
+        ResourceContent {
+            uri: "s3://bucket/sales".into(),
+            mime_type: Some(mime.into()),
+            text: Some(text.into()),
+            blob: None,
+            meta: None,
+        }
+    }
+
+    const CSV: &str = "region,month,total\nEU,Jan,120\nUS,Jan,80\nEU,Feb,95\n";
+
+    #[test]
+    fn test_csv_select_and_filter() {
+        let query = json!({"columns": ["month", "total"], "where": {"region": "EU", "total": {"gte": 100}}});
+        let out = TableQuery.query(content("text/csv", CSV), &query).unwrap();
+        assert_eq!(out.text.as_deref(), Some("month,total\nJan,120\n"));
+        assert_eq!(out.mime_type.as_deref(), Some("text/csv"));
+    }
+
+    #[test]
+    fn test_json_select_and_filter() {
+        let text = r#"[{"region":"EU","total":120,"tags":["q1"]},{"region":"US","total":80,"tags":[]}]"#;
+        let query = json!({"columns": ["total"], "where": {"tags": {"contains": "q1"}}});
+        let out = TableQuery.query(content("application/json", text), &query).unwrap();
+        assert_eq!(out.text.as_deref(), Some(r#"[{"total":120}]"#));
+
+        let query = json!({"where": {"total": {"lt": 100}}});
+        let out = TableQuery.query(content("application/json", text), &query).unwrap();
+        let v: Value = serde_json::from_str(out.text.as_deref().unwrap()).unwrap();
+        assert_eq!(v[0]["region"], "US");
+    }
+
+    #[test]
+    fn test_invalid_queries() {
+        for query in [
+            json!({"columns": ["nope"]}),
+            json!({"where": {"total": {"between": [1, 2]}}}),
+            json!({"where": {"total": {"gt": 1, "lt": 2}}}),
+            json!(["total"]),
+        ] {
+            let err = TableQuery.query(content("text/csv", CSV), &query).unwrap_err();
+            assert!(matches!(err, McpError::Validation(_)), "{query}");
+        }
+        let err = TableQuery.query(content("text/plain", "x"), &json!({})).unwrap_err();
+        assert!(matches!(err, McpError::Validation(_)));
+    }
+}
@@ -0,0 +1,306 @@
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use serde_json::{Map, Value};
+
+use crate::types::{McpError, ResourceContent};
+
+/// Converts resource text from one MIME type to another.
+///
+/// Registered per `(from, to)` pair with
+/// [`ServerBuilder::renderer`](crate::server::ServerBuilder::renderer);
+/// `resources/read` runs one when the client asks for a `mimeType` the
+/// handler didn't produce.
+pub trait Renderer: Send + Sync {
+    fn render(&self, text: &str) -> Result<String, McpError>;
+}
+
+/// Renders `text/csv` with a header row as a JSON array of objects.  All
+/// values are strings.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct CsvToJson;
+
+impl Renderer for CsvToJson {
+    fn render(&self, text: &str) -> Result<String, McpError> {
+        let mut rows = parse_csv(text)?.into_iter();
+        let header = rows.next().unwrap_or_default();
+        let records: Vec<Value> = rows
+            .map(|row| {
+                let fields: Map<String, Value> = header
+                    .iter()
+                    .cloned()
+                    .zip(row.into_iter().map(Value::String))
+                    .collect();
+                Value::Object(fields)
+            })
+            .collect();
+        Ok(Value::Array(records).to_string())
+    }
+}
+
+/// Renders a JSON array of objects as `text/csv`.  The header is the union
+/// of keys in first-seen order; nulls and missing keys become empty cells
+/// and non-string values are written as JSON.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct JsonToCsv;
+
+impl Renderer for JsonToCsv {
+    fn render(&self, text: &str) -> Result<String, McpError> {
+        let value: Value = serde_json::from_str(text)?;
+        let records = value
+            .as_array()
+            .ok_or_else(|| McpError::Validation("expected a JSON array of objects".into()))?;
+
+        let mut header: Vec<&str> = Vec::new();
+        for record in records {
+            let fields = record
+                .as_object()
+                .ok_or_else(|| McpError::Validation("expected a JSON array of objects".into()))?;
+            for key in fields.keys() {
+                if !header.contains(&key.as_str()) {
+                    header.push(key);
+                }
+            }
+        }
+
+        let mut out = String::new();
+        write_row(&mut out, header.iter().map(|h| h.to_string()));
+        for record in records {
+            write_row(
+                &mut out,
+                header.iter().map(|key| match record.get(*key) {
+                    None | Some(Value::Null) => String::new(),
+                    Some(Value::String(s)) => s.clone(),
+                    Some(v) => v.to_string(),
+                }),
+            );
+        }
+        Ok(out)
+    }
+}
+
+/// Renderers keyed by `(from, to)` MIME type.
+#[derive(Clone)]
+pub(crate) struct Renderers {
+    by_pair: HashMap<(String, String), Arc<dyn Renderer>>,
+}
+
+impl Default for Renderers {
+    /// The built-in CSV ⇄ JSON pair.
+    fn default() -> Self {
+        let mut renderers = Renderers { by_pair: HashMap::new() };
+        renderers.insert("text/csv", "application/json", Arc::new(CsvToJson));
+        renderers.insert("application/json", "text/csv", Arc::new(JsonToCsv));
+        renderers
+    }
+}
+
+impl Renderers {
+    pub(crate) fn insert(&mut self, from: &str, to: &str, renderer: Arc<dyn Renderer>) {
+        self.by_pair.insert((from.to_string(), to.to_string()), renderer);
+    }
+
+    /// Return `content` as `to`.  Content already of that type passes
+    /// through untouched.
+    pub(crate) fn convert(&self, content: ResourceContent, to: &str) -> Result<ResourceContent, String> {
+        let from = content.mime_type.as_deref().unwrap_or_default();
+        if from == to {
+            return Ok(content);
+        }
+        let renderer = self
+            .by_pair
+            .get(&(from.to_string(), to.to_string()))
+            .ok_or_else(|| format!("no renderer from {} to {}", from, to))?;
+        let text = content
+            .text
+            .as_deref()
+            .ok_or_else(|| format!("cannot render binary {} content", from))?;
+        let rendered = renderer.render(text).map_err(|e| format!("render {}: {}", to, e))?;
+        Ok(ResourceContent {
+            mime_type: Some(to.to_string()),
+            text: Some(rendered),
+            ..content
+        })
+    }
+}
+
+/// Cut CSV or JSON-array content to its header plus the first `max_rows`
+/// rows and record `{totalRows, returnedRows, truncated}` under
+/// `_meta.preview`.  Other content, and text that doesn't parse, is
+/// returned unchanged.
+pub(crate) fn preview(content: ResourceContent, max_rows: usize) -> ResourceContent {
+    let mime = content.mime_type.as_deref().unwrap_or_default();
+    let essence = mime.split(';').next().unwrap_or_default().trim();
+    let Some(text) = content.text.as_deref() else {
+        return content;
+    };
+
+    let cut = match essence {
+        "text/csv" => parse_csv(text).ok().map(|rows| {
+            let total = rows.len().saturating_sub(1);
+            let mut out = String::new();
+            for row in rows.into_iter().take(max_rows + 1) {
+                write_row(&mut out, row.into_iter());
+            }
+            (out, total)
+        }),
+        "application/json" => match serde_json::from_str::<Value>(text) {
+            Ok(Value::Array(mut records)) => {
+                let total = records.len();
+                records.truncate(max_rows);
+                Some((Value::Array(records).to_string(), total))
+            }
+            _ => None,
+        },
+        _ => None,
+    };
+    let Some((text, total)) = cut else {
+        return content;
+    };
+
+    let summary = serde_json::json!({
+        "totalRows": total,
+        "returnedRows": total.min(max_rows),
+        "truncated": total > max_rows,
+    });
+    let mut meta = match content.meta {
+        Some(Value::Object(map)) => map,
+        _ => Map::new(),
+    };
+    meta.insert("preview".into(), summary);
+    ResourceContent {
+        text: Some(text),
+        meta: Some(Value::Object(meta)),
+        ..content
+    }
+}
+
+/// Split RFC 4180 CSV into rows of fields.  Quoted fields may contain
+/// commas, doubled quotes and line breaks; `\r\n` and `\n` both end a row.
+pub(crate) fn parse_csv(text: &str) -> Result<Vec<Vec<String>>, McpError> {
+    let mut rows = Vec::new();
+    let mut row = Vec::new();
+    let mut field = String::new();
+    let mut quoted = false;
+    let mut chars = text.chars().peekable();
+
+    while let Some(c) = chars.next() {
+        match (quoted, c) {
+            (true, '"') if chars.peek() == Some(&'"') => {
+                chars.next();
+                field.push('"');
+            }
+            (true, '"') => quoted = false,
+            (true, c) => field.push(c),
+            (false, '"') if field.is_empty() => quoted = true,
+            (false, ',') => row.push(std::mem::take(&mut field)),
+            (false, '\r') if chars.peek() == Some(&'\n') => {}
+            (false, '\n') => {
+                row.push(std::mem::take(&mut field));
+                rows.push(std::mem::take(&mut row));
+            }
+            (false, c) => field.push(c),
+        }
+    }
+    if quoted {
+        return Err(McpError::Validation("unterminated quoted CSV field".into()));
+    }
+    if !field.is_empty() || !row.is_empty() {
+        row.push(field);
+        rows.push(row);
+    }
+    Ok(rows)
+}
+
+pub(crate) fn write_row(out: &mut String, fields: impl Iterator<Item = String>) {
+    for (i, field) in fields.enumerate() {
+        if i > 0 {
+            out.push(',');
+        }
+        if field.contains([',', '"', '\n', '\r']) {
+            out.push('"');
+            out.push_str(&field.replace('"', "\"\""));
+            out.push('"');
+        } else {
+            out.push_str(&field);
+        }
+    }
+    out.push('\n');
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn csv(text: &str) -> ResourceContent {
+        ResourceContent {
+            uri: "s3://bucket/file.csv".into(),
+            mime_type: Some("text/csv".into()),
+            text: Some(text.into()),
+            blob: None,
+            meta: None,
+        }
+    }
+
+    #[test]
+    fn test_csv_to_json() {
+        let out = Renderers::default()
+            .convert(csv("month,total\r\nJan,\"1,200\"\r\nFeb,\"say \"\"hi\"\"\"\r\n"), "application/json")
+            .unwrap();
+        assert_eq!(out.mime_type.as_deref(), Some("application/json"));
+        assert_eq!(out.uri, "s3://bucket/file.csv");
+        let v: Value = serde_json::from_str(out.text.as_deref().unwrap()).unwrap();
+        assert_eq!(
+            v,
+            json!([{"month": "Jan", "total": "1,200"}, {"month": "Feb", "total": "say \"hi\""}])
+        );
+    }
+
+    #[test]
+    fn test_json_to_csv() {
+        let text = JsonToCsv
+            .render(r#"[{"month":"Jan","total":1200},{"month":"Feb, late","note":null}]"#)
+            .unwrap();
+        assert_eq!(text, "month,total,note\nJan,1200,\n\"Feb, late\",,\n");
+        assert!(JsonToCsv.render(r#"{"month":"Jan"}"#).is_err());
+    }
+
+    #[test]
+    fn test_preview() {
+        let out = preview(csv("month,total\nJan,1\n\"Feb\nlate\",2\nMar,3\n"), 2);
+        assert_eq!(out.text.as_deref(), Some("month,total\nJan,1\n\"Feb\nlate\",2\n"));
+        assert_eq!(
+            out.meta.unwrap()["preview"],
+            json!({"totalRows": 3, "returnedRows": 2, "truncated": true})
+        );
+
+        let mut content = csv(r#"[{"a":1},{"a":2}]"#);
+        content.mime_type = Some("application/json; charset=utf-8".into());
+        content.meta = Some(json!({"etag": "x"}));
+        let out = preview(content, 5);
+        assert_eq!(out.text.as_deref(), Some(r#"[{"a":1},{"a":2}]"#));
+        let meta = out.meta.unwrap();
+        assert_eq!(meta["etag"], "x");
+        assert_eq!(meta["preview"]["truncated"], false);
+
+        let mut plain = csv("not tabular");
+        plain.mime_type = Some("text/plain".into());
+        assert!(preview(plain, 1).meta.is_none());
+    }
+
+    #[test]
+    fn test_convert_passthrough_and_errors() {
+        let renderers = Renderers::default();
+        let same = renderers.convert(csv("a\n1\n"), "text/csv").unwrap();
+        assert_eq!(same.text.as_deref(), Some("a\n1\n"));
+
+        assert!(renderers.convert(csv("a\n1\n"), "application/vnd.apache.parquet").is_err());
+        assert!(renderers.convert(csv("a\n\"1\n"), "application/json").is_err());
+
+        let mut blob = csv("");
+        blob.text = None;
+        blob.blob = Some("AA==".into());
+        assert!(renderers.convert(blob, "application/json").is_err());
+    }
+}
@@ -0,0 +1,148 @@
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::Duration;
+
+use serde_json::Value;
+
+/// Which requests get per-request telemetry: access log lines, metric
+/// exemplars and debug captures.  Counters and metrics still see every
+/// request.
+///
+/// A request is kept when it falls in the fixed `rate`, or when it failed
+/// and errors are always kept, or when it took at least the latency
+/// threshold.
+///
+/// ```rust
+/// # use std::time::Duration;
+/// # use mcpserver::Sampling;
+/// // 1% of traffic, plus every error and every request over 2s.
+/// let policy = Sampling::rate(0.01)
+///     .always_errors(true)
+///     .slower_than(Duration::from_secs(2));
+/// ```
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Sampling {
+    rate: f64,
+    errors: bool,
+    slower_than: Option<Duration>,
+}
+
+impl Sampling {
+    /// Keep a fixed fraction `rate` (0.0–1.0) of requests.  Selection is
+    /// deterministic: with `rate = 0.01` exactly one request in every
+    /// hundred is chosen, so samples are spread evenly over traffic
+    /// without needing a random source.
+    pub fn rate(rate: f64) -> Self {
+        Sampling {
+            rate: rate.clamp(0.0, 1.0),
+            errors: false,
+            slower_than: None,
+        }
+    }
+
+    /// Keep one request in every `n` (`0` keeps none by rate).
+    pub fn every(n: u64) -> Self {
+        Self::rate(if n == 0 { 0.0 } else { 1.0 / n as f64 })
+    }
+
+    /// Also keep every request that ended in a JSON-RPC error or an
+    /// `isError` tool result.
+    pub fn always_errors(mut self, yes: bool) -> Self {
+        self.errors = yes;
+        self
+    }
+
+    /// Also keep every request that took at least `threshold`.
+    pub fn slower_than(mut self, threshold: Duration) -> Self {
+        self.slower_than = Some(threshold);
+        self
+    }
+}
+
+impl Default for Sampling {
+    /// Every request.
+    fn default() -> Self {
+        Self::rate(1.0)
+    }
+}
+
+/// One kept request, as passed to
+/// [`MetricsSink::exemplar`](crate::MetricsSink::exemplar) and written to
+/// the access log.
+#[derive(Debug, Clone, PartialEq)]
+pub struct RequestSample {
+    pub method: String,
+    /// The tool, for `tools/call`.
+    pub tool: Option<String>,
+    pub id: Option<Value>,
+    pub session: Option<String>,
+    pub elapsed: Duration,
+    pub is_error: bool,
+}
+
+/// Applies a [`Sampling`] policy to the request stream.
+pub(crate) struct Sampler {
+    policy: Sampling,
+    seen: AtomicU64,
+}
+
+impl Sampler {
+    pub fn new(policy: Sampling) -> Self {
+        Sampler {
+            policy,
+            seen: AtomicU64::new(0),
+        }
+    }
+
+    /// Whether the next request falls in the fixed rate.  Decided before
+    /// the request runs, so a picked request's body can be captured.
+    pub fn pick(&self) -> bool {
+        let rate = self.policy.rate;
+        let n = self.seen.fetch_add(1, Ordering::Relaxed) as f64;
+        ((n + 1.0) * rate).floor() > (n * rate).floor()
+    }
+
+    /// Whether requests that weren't picked may still be kept once their
+    /// outcome is known.
+    pub fn outcome_based(&self) -> bool {
+        self.policy.errors || self.policy.slower_than.is_some()
+    }
+
+    /// Final decision once the request has finished.
+    pub fn keep(&self, picked: bool, is_error: bool, elapsed: Duration) -> bool {
+        picked
+            || (self.policy.errors && is_error)
+            || self.policy.slower_than.is_some_and(|t| elapsed >= t)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn count(policy: Sampling, requests: usize) -> usize {
+        let sampler = Sampler::new(policy);
+        (0..requests).filter(|_| sampler.pick()).count()
+    }
+
+    #[test]
+    fn test_sample_rate() {
+        assert_eq!(count(Sampling::rate(0.0), 1000), 0);
+        assert_eq!(count(Sampling::rate(0.01), 1000), 10);
+        assert_eq!(count(Sampling::rate(0.25), 1000), 250);
+        assert_eq!(count(Sampling::rate(1.0), 1000), 1000);
+        assert_eq!(count(Sampling::rate(7.0), 10), 10);
+        assert_eq!(count(Sampling::every(100), 1000), 10);
+        assert_eq!(count(Sampling::every(0), 1000), 0);
+    }
+
+    #[test]
+    fn test_keep_errors_and_slow_requests() {
+        let sampler = Sampler::new(Sampling::every(0).always_errors(true).slower_than(Duration::from_millis(500)));
+        assert!(sampler.outcome_based());
+        assert!(!sampler.keep(false, false, Duration::from_millis(10)));
+        assert!(sampler.keep(false, true, Duration::from_millis(10)));
+        assert!(sampler.keep(false, false, Duration::from_millis(500)));
+        assert!(sampler.keep(true, false, Duration::ZERO));
+        assert!(!Sampler::new(Sampling::rate(0.5)).outcome_based());
+    }
+}
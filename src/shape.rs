@@ -0,0 +1,110 @@
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use serde_json::{json, Value};
+
+use crate::types::ToolResult;
+
+/// Context field naming the [`ResultShape`] a request wants, set by the
+/// HTTP layer (e.g. from an `X-Result-Shape` header).
+pub const SHAPE_CONTEXT_KEY: &str = "resultShape";
+
+/// Serializes `tools/call` results for clients that predate the MCP result
+/// format, so they can be migrated without forking handlers.
+///
+/// Registered by name with
+/// [`ServerBuilder::result_shape`](crate::server::ServerBuilder::result_shape).
+/// A request uses one when its context has `resultShape` set to that name,
+/// or when its session's `clientInfo.name` was mapped to it with
+/// [`ServerBuilder::shape_for_client`](crate::server::ServerBuilder::shape_for_client).
+pub trait ResultShape: Send + Sync {
+    fn shape(&self, result: &ToolResult) -> Value;
+}
+
+/// `{"text": "..."}`: the text blocks joined with newlines, plus
+/// `"isError": true` for error results.  Other blocks and
+/// `structuredContent` are dropped.  Registered as `flat`.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct FlatText;
+
+impl ResultShape for FlatText {
+    fn shape(&self, result: &ToolResult) -> Value {
+        let text: Vec<&str> = result
+            .content
+            .iter()
+            .filter(|b| b.block_type == "text")
+            .filter_map(|b| b.text.as_deref())
+            .collect();
+        let mut shaped = json!({"text": text.join("\n")});
+        if result.is_error {
+            shaped["isError"] = json!(true);
+        }
+        shaped
+    }
+}
+
+pub(crate) struct Shapes {
+    by_name: HashMap<String, Arc<dyn ResultShape>>,
+    /// `clientInfo.name` → shape name.
+    by_client: HashMap<String, String>,
+}
+
+impl Default for Shapes {
+    /// The built-in `flat` shape, mapped to no clients.
+    fn default() -> Self {
+        let mut shapes = Shapes {
+            by_name: HashMap::new(),
+            by_client: HashMap::new(),
+        };
+        shapes.insert("flat", Arc::new(FlatText));
+        shapes
+    }
+}
+
+impl Shapes {
+    pub(crate) fn insert(&mut self, name: &str, shape: Arc<dyn ResultShape>) {
+        self.by_name.insert(name.to_string(), shape);
+    }
+
+    pub(crate) fn map_client(&mut self, client: &str, name: &str) {
+        self.by_client.insert(client.to_string(), name.to_string());
+    }
+
+    /// The shape for a request: the context's `resultShape` if it names a
+    /// registered shape, else the one mapped to the session's client.
+    pub(crate) fn pick(&self, context: &Value) -> Option<Arc<dyn ResultShape>> {
+        let requested = context.get(SHAPE_CONTEXT_KEY).and_then(|v| v.as_str());
+        let client = context.pointer("/client/name").and_then(|v| v.as_str());
+        requested
+            .and_then(|name| self.by_name.get(name))
+            .or_else(|| self.by_name.get(self.by_client.get(client?)?))
+            .cloned()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{error_result, image_result, structured_result};
+
+    #[test]
+    fn test_flat_text() {
+        let mut result = structured_result(json!({"n": 1}));
+        result.content.push(image_result(b"png", "image/png").content.remove(0));
+        result.content.push(crate::types::text_result("done").content.remove(0));
+        assert_eq!(FlatText.shape(&result), json!({"text": "{\"n\":1}\ndone"}));
+        assert_eq!(FlatText.shape(&error_result("boom")), json!({"text": "boom", "isError": true}));
+    }
+
+    #[test]
+    fn test_pick() {
+        let mut shapes = Shapes::default();
+        shapes.map_client("legacy-bot", "flat");
+        let flat = |ctx: Value| shapes.pick(&ctx).is_some();
+        assert!(flat(json!({"resultShape": "flat"})));
+        assert!(flat(json!({"client": {"name": "legacy-bot"}})));
+        assert!(flat(json!({"resultShape": "nope", "client": {"name": "legacy-bot"}})));
+        assert!(!flat(json!({"resultShape": "nope"})));
+        assert!(!flat(json!({"client": {"name": "desk"}})));
+    }
+}
@@ -0,0 +1,204 @@
+use std::path::PathBuf;
+use std::sync::Arc;
+
+use async_trait::async_trait;
+
+use crate::loader;
+use crate::types::{McpError, Resource, Tool};
+
+/// Somewhere tool and resource definitions can be loaded from.
+///
+/// The crate ships file and embedded sources; remote stores such as S3 are
+/// wrapped with [`FnSource`] around the caller's own client.
+#[async_trait]
+pub trait CatalogSource: Send + Sync {
+    /// Short label recorded when this source wins, e.g. `"s3"`.
+    fn name(&self) -> &str;
+
+    async fn load(&self) -> Result<(Vec<Tool>, Vec<Resource>), McpError>;
+}
+
+/// Tool and resource definitions in JSON files on disk.
+pub struct FileSource {
+    tools: PathBuf,
+    resources: PathBuf,
+}
+
+impl FileSource {
+    pub fn new(tools: impl Into<PathBuf>, resources: impl Into<PathBuf>) -> Self {
+        FileSource {
+            tools: tools.into(),
+            resources: resources.into(),
+        }
+    }
+}
+
+#[async_trait]
+impl CatalogSource for FileSource {
+    fn name(&self) -> &str {
+        "file"
+    }
+
+    async fn load(&self) -> Result<(Vec<Tool>, Vec<Resource>), McpError> {
+        Ok((loader::load_tools(&self.tools)?, loader::load_resources(&self.resources)?))
+    }
+}
+
+/// Definitions compiled into the binary, typically with `include_bytes!`,
+/// as the last resort of a [`SourceChain`].
+pub struct EmbeddedSource {
+    tools: &'static [u8],
+    resources: &'static [u8],
+}
+
+impl EmbeddedSource {
+    pub fn new(tools: &'static [u8], resources: &'static [u8]) -> Self {
+        EmbeddedSource { tools, resources }
+    }
+}
+
+#[async_trait]
+impl CatalogSource for EmbeddedSource {
+    fn name(&self) -> &str {
+        "embedded"
+    }
+
+    async fn load(&self) -> Result<(Vec<Tool>, Vec<Resource>), McpError> {
+        Ok((loader::parse_tools(self.tools)?, loader::parse_resources(self.resources)?))
+    }
+}
+
+/// Wraps an async closure into a CatalogSource.
+pub struct FnSource<F> {
+    name: String,
+    f: F,
+}
+
+impl<F, Fut> FnSource<F>
+where
+    F: Fn() -> Fut + Send + Sync + 'static,
+    Fut: std::future::Future<Output = Result<(Vec<Tool>, Vec<Resource>), McpError>>
+        + Send
+        + 'static,
+{
+    pub fn new(name: impl Into<String>, f: F) -> Self {
+        FnSource {
+            name: name.into(),
+            f,
+        }
+    }
+}
+
+#[async_trait]
+impl<F, Fut> CatalogSource for FnSource<F>
+where
+    F: Fn() -> Fut + Send + Sync + 'static,
+    Fut: std::future::Future<Output = Result<(Vec<Tool>, Vec<Resource>), McpError>>
+        + Send
+        + 'static,
+{
+    fn name(&self) -> &str {
+        &self.name
+    }
+
+    async fn load(&self) -> Result<(Vec<Tool>, Vec<Resource>), McpError> {
+        (self.f)().await
+    }
+}
+
+/// Catalog definitions and the source that produced them.
+#[derive(Debug, Clone)]
+pub struct LoadedCatalog {
+    pub tools: Vec<Tool>,
+    pub resources: Vec<Resource>,
+    /// [`name`](CatalogSource::name) of the source that won.
+    pub source: String,
+    /// Sources tried before it, with the error each returned.
+    pub skipped: Vec<(String, String)>,
+}
+
+/// Sources tried in order until one loads, so a transient outage of the
+/// primary store falls back to a local copy instead of an empty catalog.
+///
+/// ```rust,no_run
+/// # use mcpserver::{EmbeddedSource, FileSource, SourceChain};
+/// # async fn run() -> Result<(), mcpserver::McpError> {
+/// let chain = SourceChain::new()
+///     .then(FileSource::new("/mnt/config/tools.json", "/mnt/config/resources.json"))
+///     .then(EmbeddedSource::new(b"[]", b"[]"));
+/// let loaded = chain.load().await?;
+/// # Ok(())
+/// # }
+/// ```
+#[derive(Default, Clone)]
+pub struct SourceChain {
+    sources: Vec<Arc<dyn CatalogSource>>,
+}
+
+impl SourceChain {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Append a source, tried after those already added.
+    pub fn then(mut self, source: impl CatalogSource + 'static) -> Self {
+        self.sources.push(Arc::new(source));
+        self
+    }
+
+    /// Load from the first source that succeeds.  Each failure is logged;
+    /// if every source fails, the error lists them all.
+    pub async fn load(&self) -> Result<LoadedCatalog, McpError> {
+        let mut skipped = Vec::new();
+        for source in &self.sources {
+            match source.load().await {
+                Ok((tools, resources)) => {
+                    if !skipped.is_empty() {
+                        tracing::warn!(
+                            source = source.name(),
+                            skipped = skipped.len(),
+                            "catalog loaded from fallback source"
+                        );
+                    }
+                    return Ok(LoadedCatalog {
+                        tools,
+                        resources,
+                        source: source.name().to_string(),
+                        skipped,
+                    });
+                }
+                Err(e) => {
+                    tracing::warn!(source = source.name(), error = %e, "catalog source failed");
+                    skipped.push((source.name().to_string(), e.to_string()));
+                }
+            }
+        }
+        let tried: Vec<String> =
+            skipped.iter().map(|(name, e)| format!("{}: {}", name, e)).collect();
+        Err(McpError::Other(format!("no catalog source loaded ({})", tried.join("; "))))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_chain_falls_back_in_order() {
+        let chain = SourceChain::new()
+            .then(FnSource::new("s3", || async { Err(McpError::Other("connection timed out".into())) }))
+            .then(FileSource::new("/nonexistent/tools.json", "/nonexistent/resources.json"))
+            .then(EmbeddedSource::new(br#"[{"name":"echo","description":"e","inputSchema":{"type":"object"}}]"#, b"[]"));
+
+        let loaded = chain.load().await.unwrap();
+        assert_eq!(loaded.source, "embedded");
+        assert_eq!(loaded.tools[0].name, "echo");
+        assert_eq!(loaded.skipped.len(), 2);
+        assert_eq!(loaded.skipped[0], ("s3".to_string(), "connection timed out".to_string()));
+        assert_eq!(loaded.skipped[1].0, "file");
+
+        let err = SourceChain::new().then(EmbeddedSource::new(b"not json", b"[]")).load().await.unwrap_err();
+        assert!(err.to_string().starts_with("no catalog source loaded (embedded: json error"), "{err}");
+        assert!(SourceChain::new().load().await.is_err());
+    }
+}
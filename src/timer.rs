@@ -0,0 +1,169 @@
+use std::cmp::Reverse;
+use std::collections::{BinaryHeap, HashMap};
+use std::sync::{Arc, Condvar, Mutex, MutexGuard, Weak};
+use std::time::{Duration, Instant};
+
+use crate::clock::Clock;
+
+type Callback = Box<dyn FnOnce() + Send>;
+
+/// One background thread that runs callbacks at deadlines on the server's
+/// clock, for every call with a timeout.  The thread starts with the first
+/// deadline and stops when the timer is dropped.  A clock that is moved by
+/// hand wakes it through [`Clock::on_advance`].
+pub(crate) struct Timer {
+    shared: Arc<Shared>,
+}
+
+struct Shared {
+    clock: Arc<dyn Clock>,
+    state: Mutex<State>,
+    wake: Condvar,
+}
+
+#[derive(Default)]
+struct State {
+    /// Deadlines, soonest first.  Entries whose callback was cancelled stay
+    /// until they come due and are skipped then.
+    queue: BinaryHeap<Reverse<(Instant, u64)>>,
+    callbacks: HashMap<u64, Callback>,
+    next_id: u64,
+    started: bool,
+    stopped: bool,
+}
+
+impl Timer {
+    pub fn new(clock: Arc<dyn Clock>) -> Self {
+        let shared = Arc::new(Shared {
+            clock: Arc::clone(&clock),
+            state: Mutex::new(State::default()),
+            wake: Condvar::new(),
+        });
+        // Taking the lock first means the thread is either asleep or has yet
+        // to read the clock, so the jump can't be missed.
+        let weak = Arc::downgrade(&shared);
+        clock.on_advance(Box::new(move || {
+            if let Some(shared) = weak.upgrade() {
+                let _state = shared.lock();
+                shared.wake.notify_one();
+            }
+        }));
+        Timer { shared }
+    }
+
+    /// Run `f` once `after` has passed.  Dropping the returned handle
+    /// first cancels it.
+    pub fn schedule(&self, after: Duration, f: impl FnOnce() + Send + 'static) -> Scheduled {
+        let at = self.shared.clock.now() + after;
+        let mut state = self.shared.lock();
+        let id = state.next_id;
+        state.next_id += 1;
+        state.queue.push(Reverse((at, id)));
+        state.callbacks.insert(id, Box::new(f));
+        if !state.started {
+            state.started = true;
+            let shared = Arc::clone(&self.shared);
+            std::thread::spawn(move || shared.run());
+        }
+        self.shared.wake.notify_one();
+        Scheduled {
+            shared: Arc::downgrade(&self.shared),
+            id,
+        }
+    }
+}
+
+impl Drop for Timer {
+    fn drop(&mut self) {
+        self.shared.lock().stopped = true;
+        self.shared.wake.notify_one();
+    }
+}
+
+impl Shared {
+    fn lock(&self) -> MutexGuard<'_, State> {
+        self.state.lock().unwrap_or_else(|e| e.into_inner())
+    }
+
+    /// The timer thread: fire what is due, then sleep until the next
+    /// deadline or until woken by a new one.
+    fn run(&self) {
+        let mut state = self.lock();
+        while !state.stopped {
+            let now = self.clock.now();
+            let next = match state.queue.peek() {
+                Some(&Reverse((at, _))) if at <= now => {
+                    let Reverse((_, id)) = state.queue.pop().unwrap();
+                    if let Some(f) = state.callbacks.remove(&id) {
+                        drop(state);
+                        f();
+                        state = self.lock();
+                    }
+                    continue;
+                }
+                Some(&Reverse((at, _))) => Some(at - now),
+                None => None,
+            };
+            state = match next {
+                Some(wait) => match self.wake.wait_timeout(state, wait) {
+                    Ok((state, _)) => state,
+                    Err(e) => e.into_inner().0,
+                },
+                None => self.wake.wait(state).unwrap_or_else(|e| e.into_inner()),
+            };
+        }
+    }
+}
+
+/// A pending callback; dropping it cancels the callback if it hasn't run.
+pub(crate) struct Scheduled {
+    shared: Weak<Shared>,
+    id: u64,
+}
+
+impl Drop for Scheduled {
+    fn drop(&mut self) {
+        if let Some(shared) = self.shared.upgrade() {
+            shared.lock().callbacks.remove(&self.id);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::clock::{ManualClock, SystemClock};
+    use std::sync::mpsc;
+
+    #[test]
+    fn test_callbacks_fire_in_deadline_order_unless_dropped() {
+        let timer = Timer::new(Arc::new(SystemClock));
+        let (tx, rx) = mpsc::channel();
+        let fire = |n: u8| {
+            let tx = tx.clone();
+            move || tx.send(n).unwrap()
+        };
+        let _late = timer.schedule(Duration::from_millis(30), fire(3));
+        let _early = timer.schedule(Duration::from_millis(10), fire(1));
+        let cancelled = timer.schedule(Duration::from_millis(20), fire(2));
+        drop(cancelled);
+
+        let wait = Duration::from_secs(5);
+        assert_eq!(rx.recv_timeout(wait).unwrap(), 1);
+        assert_eq!(rx.recv_timeout(wait).unwrap(), 3);
+        assert!(rx.recv_timeout(Duration::from_millis(20)).is_err());
+    }
+
+    #[test]
+    fn test_manual_clock_drives_deadlines() {
+        let clock = Arc::new(ManualClock::new());
+        let timer = Timer::new(clock.clone());
+        let (tx, rx) = mpsc::channel();
+        let _pending = timer.schedule(Duration::from_secs(60), move || tx.send(()).unwrap());
+
+        clock.advance(Duration::from_secs(59));
+        assert!(rx.recv_timeout(Duration::from_millis(20)).is_err());
+        clock.advance(Duration::from_secs(1));
+        rx.recv_timeout(Duration::from_secs(5)).unwrap();
+    }
+}
@@ -0,0 +1,106 @@
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{LazyLock, Mutex};
+use std::time::Duration;
+
+use serde_json::{json, Value};
+
+/// Context key carrying the trace a handler reports into.
+pub(crate) const CONTEXT_KEY: &str = "_trace";
+
+/// Downstream calls reported so far, per open trace.
+static OPEN: LazyLock<Mutex<HashMap<u64, Vec<Value>>>> = LazyLock::new(Default::default);
+static NEXT: AtomicU64 = AtomicU64::new(1);
+
+/// Report a downstream call (database query, HTTP request, ...) made while
+/// serving a tools/call.  With
+/// [`ServerBuilder::trace_results`](crate::server::ServerBuilder::trace_results)
+/// enabled, it is listed under `_meta.trace.calls` in the result;
+/// otherwise this does nothing.
+///
+/// ```rust
+/// # use std::time::Instant;
+/// # use serde_json::{json, Value};
+/// # fn handler(context: Value) {
+/// let started = Instant::now();
+/// // ... query the warehouse ...
+/// mcpserver::trace::record_call(&context, "warehouse.query", started.elapsed(), true);
+/// # }
+/// # handler(json!({}));
+/// ```
+pub fn record_call(context: &Value, name: &str, elapsed: Duration, ok: bool) {
+    let Some(key) = context.get(CONTEXT_KEY).and_then(|v| v.as_u64()) else {
+        return;
+    };
+    let call = json!({
+        "name": name,
+        "durationMs": elapsed.as_secs_f64() * 1000.0,
+        "ok": ok,
+    });
+    if let Some(calls) = OPEN.lock().unwrap_or_else(|e| e.into_inner()).get_mut(&key) {
+        calls.push(call);
+    }
+}
+
+/// An open trace.  Dropping it (e.g. when a call is cancelled) discards
+/// whatever was recorded.
+pub(crate) struct Trace {
+    key: u64,
+}
+
+impl Trace {
+    pub(crate) fn start() -> Self {
+        let key = NEXT.fetch_add(1, Ordering::Relaxed);
+        OPEN.lock().unwrap_or_else(|e| e.into_inner()).insert(key, Vec::new());
+        Trace { key }
+    }
+
+    pub(crate) fn key(&self) -> u64 {
+        self.key
+    }
+
+    /// Close the trace and build the `_meta.trace` value.
+    pub(crate) fn finish(self, request_id: Option<&Value>, tool: &str, elapsed: Duration) -> Value {
+        let calls = OPEN
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .remove(&self.key)
+            .unwrap_or_default();
+        json!({
+            "requestId": request_id,
+            "tool": tool,
+            "durationMs": elapsed.as_secs_f64() * 1000.0,
+            "calls": calls,
+        })
+    }
+}
+
+impl Drop for Trace {
+    fn drop(&mut self) {
+        OPEN.lock().unwrap_or_else(|e| e.into_inner()).remove(&self.key);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_trace_collects_and_cleans_up() {
+        let trace = Trace::start();
+        let context = json!({ CONTEXT_KEY: trace.key() });
+        record_call(&context, "db", Duration::from_millis(3), true);
+        record_call(&json!({}), "ignored", Duration::ZERO, true);
+
+        let value = trace.finish(Some(&json!(7)), "lookup", Duration::from_millis(5));
+        assert_eq!(value["requestId"], 7);
+        assert_eq!(value["durationMs"], 5.0);
+        assert_eq!(value["calls"], json!([{"name": "db", "durationMs": 3.0, "ok": true}]));
+
+        // A finished or dropped trace no longer accepts calls.
+        record_call(&context, "late", Duration::ZERO, false);
+        let key = context[CONTEXT_KEY].as_u64().unwrap();
+        assert!(!OPEN.lock().unwrap().contains_key(&key));
+        drop(Trace::start());
+    }
+}
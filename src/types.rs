@@ -3,7 +3,7 @@ use std::sync::Arc;
 use serde::ser::SerializeMap;
 use serde::{Deserialize, Serialize};
 use serde_json::value::RawValue;
-use serde_json::Value;
+use serde_json::{Map, Value};
 
 /// JSON-RPC 2.0 error codes.
 pub const ERR_CODE_PARSE: i32 = -32700;
@@ -12,22 +12,56 @@ pub const ERR_CODE_NO_METHOD: i32 = -32601;
 pub const ERR_CODE_BAD_PARAMS: i32 = -32602;
 pub const ERR_CODE_INTERNAL: i32 = -32603;
 
-/// MCP Protocol version this server implements.
+/// Implementation-defined server error: the server is temporarily
+/// unavailable (maintenance mode).  Error `data` carries the details.
+pub const ERR_CODE_UNAVAILABLE: i32 = -32000;
+
+/// Implementation-defined server error for the HTTP layer: the session ID
+/// is unknown or was terminated (sent with HTTP 404).
+pub const ERR_CODE_SESSION_NOT_FOUND: i32 = -32001;
+
+/// MCP Protocol version this server implements and prefers.
 pub const PROTOCOL_VERSION: &str = "2025-03-26";
 
+/// Every protocol version the server can speak, newest first.  A client
+/// requesting one of these gets it echoed back in `initialize`; any other
+/// request is answered with [`PROTOCOL_VERSION`].
+pub const SUPPORTED_PROTOCOL_VERSIONS: &[&str] = &["2025-06-18", "2025-03-26", "2024-11-05"];
+
+/// Pick the version to answer an `initialize` with.
+pub fn negotiate_protocol_version(requested: Option<&str>) -> &'static str {
+    requested
+        .and_then(|r| SUPPORTED_PROTOCOL_VERSIONS.iter().find(|v| **v == r))
+        .copied()
+        .unwrap_or(PROTOCOL_VERSION)
+}
+
 // ── Request ──
 
 /// Inbound JSON-RPC 2.0 request.
+///
+/// `id` is `None` when the member is absent (a notification) and
+/// `Some(Value::Null)` for an explicit `"id": null`, which the server
+/// rejects as an invalid request.
 #[derive(Debug, Clone, Deserialize, Serialize)]
 pub struct JsonRpcRequest {
     pub jsonrpc: String,
-    #[serde(default)]
+    #[serde(
+        default,
+        deserialize_with = "present",
+        skip_serializing_if = "Option::is_none"
+    )]
     pub id: Option<Value>,
     pub method: String,
     #[serde(default)]
     pub params: Option<Value>,
 }
 
+/// Deserialize a present member as `Some`, even when it is `null`.
+fn present<'de, D: serde::Deserializer<'de>>(d: D) -> Result<Option<Value>, D::Error> {
+    Value::deserialize(d).map(Some)
+}
+
 // ── Response ──
 
 /// Response from [`Server::handle()`](crate::Server::handle).
@@ -42,13 +76,13 @@ pub struct JsonRpcRequest {
 ///
 /// For structured inspection (e.g. in tests), call
 /// [`into_json_rpc()`](McpResponse::into_json_rpc).
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct McpResponse {
     id: Option<Value>,
     kind: ResponseKind,
 }
 
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 enum ResponseKind {
     /// Pre-serialized result — `Arc::clone` is ref-count only, zero data copy.
     Cached(Arc<RawValue>),
@@ -66,6 +100,15 @@ impl McpResponse {
         matches!(self.kind, ResponseKind::Notification)
     }
 
+    /// True for JSON-RPC errors and `isError` tool results.
+    pub(crate) fn is_error(&self) -> bool {
+        match &self.kind {
+            ResponseKind::Error(_) => true,
+            ResponseKind::Result(v) => v.get("isError") == Some(&Value::Bool(true)),
+            _ => false,
+        }
+    }
+
     /// Convert to a [`JsonRpcResponse`] for structured inspection.
     ///
     /// For cached results this parses the raw JSON back into a `Value`.
@@ -126,6 +169,22 @@ impl McpResponse {
         }
     }
 
+    pub(crate) fn error_with_data(
+        id: Option<Value>,
+        code: i32,
+        message: impl Into<String>,
+        data: Value,
+    ) -> Self {
+        McpResponse {
+            id,
+            kind: ResponseKind::Error(RpcError {
+                code,
+                message: message.into(),
+                data: Some(data),
+            }),
+        }
+    }
+
     pub(crate) fn notification() -> Self {
         McpResponse {
             id: None,
@@ -195,15 +254,120 @@ pub struct RpcError {
 // ── MCP domain types ──
 
 /// MCP tool definition loaded from config.
-#[derive(Debug, Clone, Serialize, Deserialize)]
+///
+/// Tools built in code should start from `Tool::default()` and set the
+/// fields they need, so new config fields don't break them.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub struct Tool {
     pub name: String,
+    /// Human-friendly display name; `name` stays the programmatic ID.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
     pub description: String,
     pub input_schema: Value,
+    /// JSON Schema for the tool's `structuredContent`, if declared.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub output_schema: Option<Value>,
+    /// Behaviour hints for clients (e.g. confirm before destructive calls).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub annotations: Option<ToolAnnotations>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub icons: Vec<Icon>,
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
     /// Parsed schema metadata for validation (not serialized to clients).
     #[serde(skip)]
     pub schema_meta: SchemaMeta,
+    /// Parsed `outputSchema` metadata for output validation.
+    #[serde(skip)]
+    pub output_schema_meta: SchemaMeta,
+    /// Argument objects that must pass validation (documentation contract).
+    #[serde(skip)]
+    pub examples: Vec<Value>,
+    /// Argument objects that must fail validation.
+    #[serde(skip)]
+    pub counterexamples: Vec<Value>,
+    /// Expected p95 handler latency (`latencyBudgetMs` in config).
+    #[serde(skip)]
+    pub latency_budget: Option<std::time::Duration>,
+    /// Hard ceiling on a single call's duration (`timeoutMs` in config).
+    /// Past it the handler's future is dropped and the caller gets an
+    /// error result.
+    #[serde(skip)]
+    pub timeout: Option<std::time::Duration>,
+    /// Ceiling on the serialized size of a call's result, in bytes
+    /// (`maxOutputBytes` in config).
+    #[serde(skip)]
+    pub max_output_bytes: Option<usize>,
+    /// Output content-security policy (`outputPolicy` in config); falls
+    /// back to the server default when unset.
+    #[serde(skip)]
+    pub output_policy: Option<crate::filter::FilterPolicy>,
+    /// Resource that each successful call's output is published as
+    /// (`publishAs` in config).  Listed alongside the static resources.
+    #[serde(skip)]
+    pub publish_as: Option<Resource>,
+    /// Steps of a composite tool (`steps` in config).  A tool with steps
+    /// runs them in order instead of calling a registered handler.
+    #[serde(skip)]
+    pub steps: Vec<crate::pipeline::Step>,
+    /// Session conditions under which the tool is listed and callable
+    /// (`visibleWhen` in config); always visible when unset.
+    #[serde(skip)]
+    pub visible_when: Option<crate::visibility::Visibility>,
+    /// Client capabilities the tool cannot work without, e.g. `sampling`
+    /// (`requiresClientCapabilities` in config).  Hidden from and rejected
+    /// for sessions whose client didn't declare them.
+    #[serde(skip)]
+    pub requires_client: Vec<String>,
+    /// Names of [`HealthCheck`](crate::health::HealthCheck)s the tool needs
+    /// (`dependsOn` in config).  Hidden and rejected while one is failing.
+    #[serde(skip)]
+    pub depends_on: Vec<String>,
+    /// Local command the tool runs instead of a registered handler (`exec`
+    /// in config).
+    #[serde(skip)]
+    pub exec: Option<crate::exec::ExecSpec>,
+    /// Rewrite dates and numbers in text results for the session's locale
+    /// (`localize` in config).
+    #[serde(skip)]
+    pub localize: bool,
+}
+
+impl Tool {
+    /// The tool's `category` (`_meta.category`), if it has one.
+    pub fn category(&self) -> Option<&str> {
+        self.meta.as_ref()?.get("category")?.as_str()
+    }
+
+    /// The tool's `tags` (`_meta.tags`).
+    pub fn tags(&self) -> Vec<&str> {
+        self.meta
+            .as_ref()
+            .and_then(|m| m.get("tags"))
+            .and_then(|t| t.as_array())
+            .map(|tags| tags.iter().filter_map(|t| t.as_str()).collect())
+            .unwrap_or_default()
+    }
+
+    /// Whether `group` is the tool's category or one of its tags.
+    pub fn in_group(&self, group: &str) -> bool {
+        self.category() == Some(group) || self.tags().contains(&group)
+    }
+}
+
+/// An icon a client can show next to a tool, resource or prompt.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Icon {
+    /// URL or `data:` URI of the image.
+    pub src: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mime_type: Option<String>,
+    /// Sizes the image is suitable for, e.g. `["48x48"]`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub sizes: Vec<String>,
 }
 
 /// MCP resource definition.
@@ -211,18 +375,125 @@ pub struct Tool {
 #[serde(rename_all = "camelCase")]
 pub struct Resource {
     pub name: String,
+    /// Human-friendly display name; `name` stays the programmatic ID.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
     pub description: String,
     pub uri: String,
     pub mime_type: String,
+    /// Other MIME types `resources/read` can serve this resource as, when
+    /// the client passes `mimeType`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub formats: Vec<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub icons: Vec<Icon>,
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
 }
 
-/// Tool call result returned by handlers.
+/// MCP resource template: a parameterized resource addressed by an
+/// RFC 6570-style URI template such as `channel://{channelId}/messages`.
 #[derive(Debug, Clone, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
+pub struct ResourceTemplate {
+    pub uri_template: String,
+    pub name: String,
+    /// Human-friendly display name; `name` stays the programmatic ID.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mime_type: Option<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub icons: Vec<Icon>,
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
+}
+
+/// MCP prompt definition loaded from config.
+///
+/// `messages` holds the message templates (with `{{argument}}`
+/// placeholders) and is not part of prompts/list.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Prompt {
+    pub name: String,
+    /// Human-friendly display name; `name` stays the programmatic ID.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub arguments: Vec<PromptArgument>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub icons: Vec<Icon>,
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
+    #[serde(default, skip_serializing)]
+    pub messages: Vec<PromptMessage>,
+}
+
+/// A declared prompt argument.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PromptArgument {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub required: bool,
+}
+
+/// A single message returned by prompts/get.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PromptMessage {
+    pub role: String,
+    pub content: ContentBlock,
+}
+
+/// MCP tool annotations.  All fields are hints: clients must not rely on
+/// them for security decisions.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ToolAnnotations {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub title: Option<String>,
+    /// The tool does not modify its environment.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub read_only_hint: Option<bool>,
+    /// The tool may perform destructive updates (only meaningful when not
+    /// read-only).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub destructive_hint: Option<bool>,
+    /// Repeating a call with the same arguments has no further effect.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub idempotent_hint: Option<bool>,
+    /// The tool interacts with external entities (e.g. the web).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub open_world_hint: Option<bool>,
+}
+
+/// Tool call result returned by handlers.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
 pub struct ToolResult {
     pub content: Vec<ContentBlock>,
+    /// Machine-readable result matching the tool's `outputSchema`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub structured_content: Option<Value>,
     #[serde(default, skip_serializing_if = "std::ops::Not::not")]
     pub is_error: bool,
+    /// Protocol-level metadata (`_meta`), e.g. progress or vendor fields.
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
+}
+
+impl ToolResult {
+    /// Attach `_meta` to the result.
+    pub fn with_meta(mut self, meta: Value) -> Self {
+        self.meta = Some(meta);
+        self
+    }
 }
 
 /// Single content block in a tool result.
@@ -232,10 +503,15 @@ pub struct ContentBlock {
     pub block_type: String,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub text: Option<String>,
+    /// Base64-encoded payload of an `image` block.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub data: Option<String>,
+    #[serde(default, rename = "mimeType", skip_serializing_if = "Option::is_none")]
+    pub mime_type: Option<String>,
 }
 
 /// Resource content returned by resource handlers.
-#[derive(Debug, Clone, Serialize, Deserialize)]
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub struct ResourceContent {
     pub uri: String,
@@ -245,6 +521,9 @@ pub struct ResourceContent {
     pub text: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub blob: Option<String>,
+    /// Protocol-level metadata (`_meta`) for this content item.
+    #[serde(rename = "_meta", default, skip_serializing_if = "Option::is_none")]
+    pub meta: Option<Value>,
 }
 
 /// Parsed schema metadata used for argument validation.
@@ -269,8 +548,47 @@ pub fn text_result(text: impl Into<String>) -> ToolResult {
         content: vec![ContentBlock {
             block_type: "text".into(),
             text: Some(text.into()),
+            data: None,
+            mime_type: None,
+        }],
+        structured_content: None,
+        is_error: false,
+        meta: None,
+    }
+}
+
+/// Create a structured tool result.  `structuredContent` carries `value`;
+/// a text block carries the same JSON for clients that predate structured
+/// output.
+pub fn structured_result(value: impl Serialize) -> ToolResult {
+    let value = serde_json::to_value(value).unwrap_or(Value::Null);
+    ToolResult {
+        content: vec![ContentBlock {
+            block_type: "text".into(),
+            text: Some(value.to_string()),
+            data: None,
+            mime_type: None,
+        }],
+        structured_content: Some(value),
+        is_error: false,
+        meta: None,
+    }
+}
+
+/// Create a tool result holding one image block, e.g. a rendered chart
+/// or a screenshot.  `data` is the raw image bytes; it is base64-encoded
+/// here.
+pub fn image_result(data: &[u8], mime_type: impl Into<String>) -> ToolResult {
+    ToolResult {
+        content: vec![ContentBlock {
+            block_type: "image".into(),
+            text: None,
+            data: Some(base64_encode(data)),
+            mime_type: Some(mime_type.into()),
         }],
+        structured_content: None,
         is_error: false,
+        meta: None,
     }
 }
 
@@ -280,8 +598,25 @@ pub fn error_result(text: impl Into<String>) -> ToolResult {
         content: vec![ContentBlock {
             block_type: "text".into(),
             text: Some(text.into()),
+            data: None,
+            mime_type: None,
         }],
+        structured_content: None,
         is_error: true,
+        meta: None,
+    }
+}
+
+/// Create a prompt message with a single text content block.
+pub fn text_message(role: impl Into<String>, text: impl Into<String>) -> PromptMessage {
+    PromptMessage {
+        role: role.into(),
+        content: ContentBlock {
+            block_type: "text".into(),
+            text: Some(text.into()),
+            data: None,
+            mime_type: None,
+        },
     }
 }
 
@@ -299,6 +634,273 @@ pub fn new_error_response(id: Option<Value>, code: i32, message: impl Into<Strin
     }
 }
 
+/// Parse an HTTP (or Lambda) request body into a [`JsonRpcRequest`].
+///
+/// On failure, returns the JSON-RPC error to send back with HTTP 400 —
+/// `-32700` for invalid JSON, `-32600` for JSON that isn't a request — so
+/// transport errors share the envelope of every other error.
+// The error is the response itself, built once on a rejected request and
+// sent as-is; boxing it would only cost callers a deref.
+#[allow(clippy::result_large_err)]
+pub fn parse_request(body: &[u8]) -> Result<JsonRpcRequest, JsonRpcResponse> {
+    let value: Value = serde_json::from_slice(body)
+        .map_err(|e| new_error_response(None, ERR_CODE_PARSE, format!("Parse error: {}", e)))?;
+    let id = value.get("id").cloned();
+    serde_json::from_value(value)
+        .map_err(|e| new_error_response(id, ERR_CODE_INVALID_REQ, format!("Invalid Request: {}", e)))
+}
+
+/// Server-initiated JSON-RPC notification (no `id`, no response expected),
+/// e.g. `notifications/tools/list_changed`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct JsonRpcNotification {
+    pub jsonrpc: String,
+    pub method: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub params: Option<Value>,
+    /// Session the notification is addressed to; `None` means every
+    /// connected session.  Routing only — never serialized.
+    #[serde(skip)]
+    pub session: Option<String>,
+}
+
+impl JsonRpcNotification {
+    pub fn new(method: impl Into<String>, params: Option<Value>) -> Self {
+        JsonRpcNotification {
+            jsonrpc: "2.0".into(),
+            method: method.into(),
+            params,
+            session: None,
+        }
+    }
+
+    /// Address the notification to a single session.
+    pub fn to_session(mut self, session: impl Into<String>) -> Self {
+        self.session = Some(session.into());
+        self
+    }
+}
+
+/// A directory or file tree the client lets the server work in, from
+/// `roots/list`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Root {
+    /// A `file://` URI.
+    pub uri: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
+}
+
+/// Params of a `sampling/createMessage` request: an LLM completion the
+/// client runs on the server's behalf, sent with
+/// [`Server::create_message`](crate::Server::create_message).
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CreateMessageRequest {
+    pub messages: Vec<PromptMessage>,
+    pub max_tokens: u32,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub system_prompt: Option<String>,
+    /// Hints and priorities for the client's model choice.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub model_preferences: Option<Value>,
+    /// `none`, `thisServer` or `allServers`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub include_context: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub temperature: Option<f64>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub stop_sequences: Vec<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub metadata: Option<Value>,
+}
+
+/// The client's answer to `sampling/createMessage`.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CreateMessageResult {
+    pub role: String,
+    pub content: ContentBlock,
+    /// The model the client used.
+    pub model: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stop_reason: Option<String>,
+}
+
+/// Server-initiated JSON-RPC request (sampling, roots, elicitation,
+/// ping), created by [`Server::request`](crate::Server::request).  The
+/// client's answer comes back through
+/// [`Server::handle_client_response`](crate::Server::handle_client_response).
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ServerRequest {
+    pub jsonrpc: String,
+    pub id: Value,
+    pub method: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub params: Option<Value>,
+    /// Session the request is addressed to.  Routing only — never
+    /// serialized.
+    #[serde(skip)]
+    pub session: String,
+}
+
+/// What a client declared in `initialize`, remembered per session.
+///
+/// The server adds it to the handler context under `client` for every
+/// request of a known session; read it back with
+/// [`ClientInfo::from_context`].
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ClientInfo {
+    pub name: String,
+    pub version: String,
+    /// The version negotiated for the session, not the one requested.
+    pub protocol_version: String,
+    #[serde(default)]
+    pub capabilities: ClientCapabilities,
+}
+
+impl ClientInfo {
+    /// The client of the request's session, if the session initialized.
+    pub fn from_context(context: &Value) -> Option<Self> {
+        context
+            .get("client")
+            .and_then(|v| serde_json::from_value(v.clone()).ok())
+    }
+
+    /// Whether the client advertised a top-level capability such as
+    /// `sampling`, `roots` or `elicitation`.
+    pub fn supports(&self, capability: &str) -> bool {
+        self.capabilities.has(capability)
+    }
+}
+
+/// A capability whose only option is `listChanged`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ListChangedCapability {
+    #[serde(default)]
+    pub list_changed: bool,
+}
+
+/// The server's `resources` capability.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ResourcesCapability {
+    #[serde(default)]
+    pub subscribe: bool,
+    #[serde(default)]
+    pub list_changed: bool,
+}
+
+/// Capabilities the server advertises in its `initialize` result, per
+/// protocol version (see [`Server::capabilities`](crate::Server::capabilities)).
+/// Capabilities without options, such as `logging`, are an empty map when
+/// present.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ServerCapabilities {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tools: Option<ListChangedCapability>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub resources: Option<ResourcesCapability>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub prompts: Option<ListChangedCapability>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub logging: Option<Map<String, Value>>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub completions: Option<Map<String, Value>>,
+    /// Vendor extensions by name.
+    #[serde(default, skip_serializing_if = "Map::is_empty")]
+    pub experimental: Map<String, Value>,
+}
+
+/// Capabilities a client declares in `initialize`.  Ones this crate
+/// doesn't model are kept in `other` as sent.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ClientCapabilities {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub roots: Option<ListChangedCapability>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub sampling: Option<Map<String, Value>>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub elicitation: Option<Map<String, Value>>,
+    #[serde(default, skip_serializing_if = "Map::is_empty")]
+    pub experimental: Map<String, Value>,
+    #[serde(flatten)]
+    pub other: Map<String, Value>,
+}
+
+impl ClientCapabilities {
+    /// Whether the top-level capability `name` was declared.
+    pub fn has(&self, name: &str) -> bool {
+        match name {
+            "roots" => self.roots.is_some(),
+            "sampling" => self.sampling.is_some(),
+            "elicitation" => self.elicitation.is_some(),
+            "experimental" => !self.experimental.is_empty(),
+            other => self.other.get(other).is_some_and(|v| !v.is_null()),
+        }
+    }
+}
+
+/// What a completion request is for: a prompt by name, or a resource
+/// (template) by URI.
+#[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(tag = "type")]
+pub enum CompletionRef {
+    #[serde(rename = "ref/prompt")]
+    Prompt { name: String },
+    #[serde(rename = "ref/resource")]
+    Resource { uri: String },
+}
+
+/// Candidate values for a completion request.
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Completion {
+    pub values: Vec<String>,
+    /// Total number of matches, when known and larger than `values`.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub total: Option<usize>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub has_more: bool,
+}
+
+#[derive(Debug, Deserialize)]
+pub(crate) struct CompleteParams {
+    #[serde(rename = "ref")]
+    pub reference: CompletionRef,
+    pub argument: CompleteArgument,
+}
+
+#[derive(Debug, Deserialize)]
+pub(crate) struct CompleteArgument {
+    pub name: String,
+    #[serde(default)]
+    pub value: String,
+}
+
+/// MCP log severity (RFC 5424 levels, least to most severe).
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum LogLevel {
+    Debug,
+    Info,
+    Notice,
+    Warning,
+    Error,
+    Critical,
+    Alert,
+    Emergency,
+}
+
+#[derive(Debug, Deserialize)]
+pub(crate) struct SetLevelParams {
+    pub level: LogLevel,
+}
+
 /// Build a JSON-RPC success response.
 pub fn new_ok_response(id: Option<Value>, result: Value) -> JsonRpcResponse {
     JsonRpcResponse {
@@ -343,10 +945,111 @@ pub(crate) struct ToolCallParams {
     pub arguments: Value,
 }
 
+#[derive(Debug, Deserialize)]
+pub(crate) struct PromptGetParams {
+    pub name: String,
+    #[serde(default)]
+    pub arguments: serde_json::Map<String, Value>,
+}
+
 #[derive(Debug, Deserialize)]
 pub(crate) struct ResourceReadParams {
     #[serde(default)]
     pub name: Option<String>,
     #[serde(default)]
     pub uri: Option<String>,
+    #[serde(default, rename = "mimeType")]
+    pub mime_type: Option<String>,
+    #[serde(default, rename = "maxRows")]
+    pub max_rows: Option<usize>,
+    #[serde(default)]
+    pub query: Option<Value>,
+}
+
+/// Standard (RFC 4648) base64 with padding.
+fn base64_encode(bytes: &[u8]) -> String {
+    const ALPHABET: &[u8; 64] = b"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/";
+    let mut out = String::with_capacity(bytes.len().div_ceil(3) * 4);
+    for chunk in bytes.chunks(3) {
+        let n = chunk.iter().enumerate().fold(0u32, |n, (i, &b)| n | (b as u32) << (16 - 8 * i));
+        for i in 0..4 {
+            if i <= chunk.len() {
+                out.push(ALPHABET[(n >> (18 - 6 * i) & 0x3f) as usize] as char);
+            } else {
+                out.push('=');
+            }
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_request_null_id_is_not_a_notification() {
+        let req: JsonRpcRequest = serde_json::from_str(r#"{"jsonrpc":"2.0","id":null,"method":"ping"}"#).unwrap();
+        assert_eq!(req.id, Some(Value::Null));
+        let req: JsonRpcRequest = serde_json::from_str(r#"{"jsonrpc":"2.0","method":"ping"}"#).unwrap();
+        assert_eq!(req.id, None);
+        assert!(!serde_json::to_string(&req).unwrap().contains("\"id\""));
+    }
+
+    #[test]
+    fn test_parse_request() {
+        let req = parse_request(br#"{"jsonrpc":"2.0","id":1,"method":"ping"}"#).unwrap();
+        assert_eq!(req.method, "ping");
+
+        let err = parse_request(b"{not json").unwrap_err();
+        assert_eq!(err.error.unwrap().code, ERR_CODE_PARSE);
+        let err = parse_request(br#"{"jsonrpc":"2.0","id":7}"#).unwrap_err();
+        assert_eq!(err.id, Some(serde_json::json!(7)));
+        assert_eq!(err.error.unwrap().code, ERR_CODE_INVALID_REQ);
+    }
+
+    #[test]
+    fn test_client_capabilities() {
+        let sent = serde_json::json!({
+            "roots": {"listChanged": true},
+            "sampling": {},
+            "experimental": {"acme/trace": {}},
+            "acme/colors": {"depth": 24},
+            "elicitation": null,
+        });
+        let caps: ClientCapabilities = serde_json::from_value(sent).unwrap();
+        assert_eq!(caps.roots, Some(ListChangedCapability { list_changed: true }));
+        assert!(caps.has("sampling") && caps.has("acme/colors") && caps.has("experimental"));
+        assert!(!caps.has("elicitation") && !caps.has("tasks"));
+        assert_eq!(
+            serde_json::to_value(&caps).unwrap(),
+            serde_json::json!({
+                "roots": {"listChanged": true},
+                "sampling": {},
+                "experimental": {"acme/trace": {}},
+                "acme/colors": {"depth": 24},
+            })
+        );
+    }
+
+    #[test]
+    fn test_base64_encode() {
+        assert_eq!(base64_encode(b""), "");
+        assert_eq!(base64_encode(b"f"), "Zg==");
+        assert_eq!(base64_encode(b"fo"), "Zm8=");
+        assert_eq!(base64_encode(b"foo"), "Zm9v");
+        assert_eq!(base64_encode(b"foobar"), "Zm9vYmFy");
+        assert_eq!(base64_encode(&[0xff, 0xfe, 0xfd]), "//79");
+    }
+
+    #[test]
+    fn test_image_result_serialization() {
+        let v = serde_json::to_value(image_result(b"\x89PNG", "image/png")).unwrap();
+        assert_eq!(
+            v["content"][0],
+            serde_json::json!({"type": "image", "data": "iVBORw==", "mimeType": "image/png"})
+        );
+        let v = serde_json::to_value(text_result("hi")).unwrap();
+        assert_eq!(v["content"][0], serde_json::json!({"type": "text", "text": "hi"}));
+    }
 }
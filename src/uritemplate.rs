@@ -0,0 +1,148 @@
+use std::collections::HashMap;
+
+/// One piece of a parsed URI template.
+#[derive(Debug, PartialEq)]
+enum Part<'a> {
+    Literal(&'a str),
+    /// `{name}` matches one path segment; `{+name}` may span `/`.
+    Var { name: &'a str, reserved: bool },
+}
+
+/// Match `uri` against an RFC 6570-style template such as
+/// `channel://{channelId}/messages`, returning the extracted variables.
+///
+/// Supports simple (`{var}`) and reserved (`{+var}`) expansions, which is
+/// what resource URIs use in practice.  Simple values are percent-decoded;
+/// reserved values are returned as-is.  Returns `None` when the URI does
+/// not match or the template is malformed.
+pub(crate) fn match_template(template: &str, uri: &str) -> Option<HashMap<String, String>> {
+    let parts = parse(template)?;
+    let mut vars = HashMap::new();
+    if match_parts(&parts, uri, &mut vars) {
+        Some(vars)
+    } else {
+        None
+    }
+}
+
+fn parse(template: &str) -> Option<Vec<Part<'_>>> {
+    let mut parts = Vec::new();
+    let mut rest = template;
+    while !rest.is_empty() {
+        match rest.find('{') {
+            Some(0) => {
+                let end = rest.find('}')?;
+                let expr = &rest[1..end];
+                let (name, reserved) = match expr.strip_prefix('+') {
+                    Some(name) => (name, true),
+                    None => (expr, false),
+                };
+                if name.is_empty() || name.contains(['{', ',', '*']) {
+                    return None;
+                }
+                parts.push(Part::Var { name, reserved });
+                rest = &rest[end + 1..];
+            }
+            Some(i) => {
+                parts.push(Part::Literal(&rest[..i]));
+                rest = &rest[i..];
+            }
+            None => {
+                parts.push(Part::Literal(rest));
+                rest = "";
+            }
+        }
+    }
+    Some(parts)
+}
+
+fn match_parts(parts: &[Part<'_>], uri: &str, vars: &mut HashMap<String, String>) -> bool {
+    let Some((first, tail)) = parts.split_first() else {
+        return uri.is_empty();
+    };
+    match first {
+        Part::Literal(lit) => match uri.strip_prefix(lit) {
+            Some(rest) => match_parts(tail, rest, vars),
+            None => false,
+        },
+        Part::Var { name, reserved } => {
+            // Try the shortest capture first so a trailing literal anchors
+            // the match (`{id}/messages` stops at the first `/messages`).
+            for end in (1..=uri.len()).filter(|&i| uri.is_char_boundary(i)) {
+                let value = &uri[..end];
+                if !reserved && value.contains('/') {
+                    break;
+                }
+                if match_parts(tail, &uri[end..], vars) {
+                    let value = if *reserved {
+                        value.to_string()
+                    } else {
+                        percent_decode(value)
+                    };
+                    vars.insert(name.to_string(), value);
+                    return true;
+                }
+            }
+            false
+        }
+    }
+}
+
+fn percent_decode(s: &str) -> String {
+    let bytes = s.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%' && i + 2 < bytes.len() {
+            if let (Some(hi), Some(lo)) = (hex_val(bytes[i + 1]), hex_val(bytes[i + 2])) {
+                out.push(hi << 4 | lo);
+                i += 3;
+                continue;
+            }
+        }
+        out.push(bytes[i]);
+        i += 1;
+    }
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+fn hex_val(b: u8) -> Option<u8> {
+    (b as char).to_digit(16).map(|d| d as u8)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_simple_variable() {
+        let vars = match_template("channel://{channelId}/messages", "channel://general/messages").unwrap();
+        assert_eq!(vars["channelId"], "general");
+    }
+
+    #[test]
+    fn test_multiple_variables_and_decoding() {
+        let vars = match_template("db://{schema}/{table}", "db://public/order%20items").unwrap();
+        assert_eq!(vars["schema"], "public");
+        assert_eq!(vars["table"], "order items");
+    }
+
+    #[test]
+    fn test_simple_variable_does_not_span_segments() {
+        assert!(match_template("channel://{channelId}/messages", "channel://a/b/messages").is_none());
+        assert!(match_template("channel://{channelId}/messages", "channel:///messages").is_none());
+        assert!(match_template("channel://{channelId}/messages", "channel://a/threads").is_none());
+    }
+
+    #[test]
+    fn test_reserved_variable_spans_segments() {
+        let vars = match_template("file:///{+path}", "file:///var/log/app.log").unwrap();
+        assert_eq!(vars["path"], "var/log/app.log");
+    }
+
+    #[test]
+    fn test_malformed_template() {
+        assert!(match_template("x://{unclosed", "x://a").is_none());
+        assert!(match_template("x://{}", "x://a").is_none());
+    }
+}
@@ -1,46 +1,85 @@
 use serde_json::Value;
-use crate::types::Tool;
+use crate::types::{SchemaMeta, Tool};
 
 impl Tool {
     /// Validate arguments against the tool's input schema metadata.
     pub fn validate_arguments(&self, args: &Value) -> Result<(), String> {
-        let empty = serde_json::Map::new();
-        let obj = args.as_object().unwrap_or(&empty);
-        let meta = &self.schema_meta;
-
-        // Check required fields.
-        for field in &meta.required {
-            if !obj.contains_key(field) {
-                return Err(format!("missing required field \"{}\"", field));
-            }
+        check_meta(&self.schema_meta, args)
+    }
+
+    /// Validate a result's `structuredContent` against the tool's
+    /// `outputSchema`.  Tools without an output schema accept anything.
+    pub fn validate_output(&self, structured: Option<&Value>) -> Result<(), String> {
+        if self.output_schema.is_none() {
+            return Ok(());
+        }
+        match structured {
+            Some(v) if v.is_object() => check_meta(&self.output_schema_meta, v),
+            Some(_) => Err("structuredContent must be an object".into()),
+            None => Err("tool declares outputSchema but returned no structuredContent".into()),
         }
+    }
 
-        // Check oneOf — at least one set of required fields must be satisfied.
-        if !meta.one_of.is_empty() {
-            let satisfied = meta.one_of.iter().any(|set| {
-                set.required.iter().all(|f| obj.contains_key(f))
-            });
-            if !satisfied {
-                return Err("arguments must satisfy oneOf requirements".into());
+    /// Check the tool's documented `examples` and `counterexamples` against
+    /// its schema.  Returns one message per contract violation: an example
+    /// that fails validation, or a counterexample that passes.
+    pub fn verify_examples(&self) -> Vec<String> {
+        let mut failures = Vec::new();
+        for (i, example) in self.examples.iter().enumerate() {
+            if let Err(e) = self.validate_arguments(example) {
+                failures.push(format!("{}: example #{} rejected: {}", self.name, i + 1, e));
+            }
+        }
+        for (i, counter) in self.counterexamples.iter().enumerate() {
+            if self.validate_arguments(counter).is_ok() {
+                failures.push(format!(
+                    "{}: counterexample #{} unexpectedly accepted",
+                    self.name,
+                    i + 1
+                ));
             }
         }
+        failures
+    }
+}
+
+/// Check an object against parsed schema metadata.
+fn check_meta(meta: &SchemaMeta, value: &Value) -> Result<(), String> {
+    let empty = serde_json::Map::new();
+    let obj = value.as_object().unwrap_or(&empty);
+
+    // Check required fields.
+    for field in &meta.required {
+        if !obj.contains_key(field) {
+            return Err(format!("missing required field \"{}\"", field));
+        }
+    }
 
-        // Check dependencies — if field A is present, fields B must also be present.
-        for (field, deps) in &meta.dependencies {
-            if obj.contains_key(field) {
-                for dep in deps {
-                    if !obj.contains_key(dep) {
-                        return Err(format!(
-                            "field \"{}\" requires \"{}\" to also be present",
-                            field, dep
-                        ));
-                    }
+    // Check oneOf — at least one set of required fields must be satisfied.
+    if !meta.one_of.is_empty() {
+        let satisfied = meta.one_of.iter().any(|set| {
+            set.required.iter().all(|f| obj.contains_key(f))
+        });
+        if !satisfied {
+            return Err("arguments must satisfy oneOf requirements".into());
+        }
+    }
+
+    // Check dependencies — if field A is present, fields B must also be present.
+    for (field, deps) in &meta.dependencies {
+        if obj.contains_key(field) {
+            for dep in deps {
+                if !obj.contains_key(dep) {
+                    return Err(format!(
+                        "field \"{}\" requires \"{}\" to also be present",
+                        field, dep
+                    ));
                 }
             }
         }
-
-        Ok(())
     }
+
+    Ok(())
 }
 
 #[cfg(test)]
@@ -57,6 +96,20 @@ mod tests {
         tools.into_iter().next().unwrap()
     }
 
+    #[test]
+    fn test_validate_output() {
+        let json = r#"[{"name":"t","description":"t","inputSchema":{"type":"object"},
+            "outputSchema":{"type":"object","required":["id"]}}]"#;
+        let tool = parse_tools(json.as_bytes()).unwrap().remove(0);
+        assert!(tool.validate_output(Some(&serde_json::json!({"id": 1}))).is_ok());
+        assert!(tool.validate_output(Some(&serde_json::json!({}))).is_err());
+        assert!(tool.validate_output(Some(&serde_json::json!([1]))).is_err());
+        assert!(tool.validate_output(None).is_err());
+
+        let untyped = make_tool(r#"{"type":"object"}"#);
+        assert!(untyped.validate_output(None).is_ok());
+    }
+
     #[test]
     fn test_validate_required_present() {
         let tool = make_tool(r#"{"type":"object","properties":{},"required":["name"]}"#);
@@ -110,6 +163,17 @@ mod tests {
         assert!(err.contains("requires"));
     }
 
+    #[test]
+    fn test_verify_examples() {
+        let json = r#"[{"name":"t","description":"t","inputSchema":{"type":"object","required":["a"]},
+            "examples":[{"a":1},{"b":1}],"counterexamples":[{},{"a":2}]}]"#;
+        let tool = parse_tools(json.as_bytes()).unwrap().remove(0);
+        let failures = tool.verify_examples();
+        assert_eq!(failures.len(), 2);
+        assert!(failures[0].contains("example #2 rejected"));
+        assert!(failures[1].contains("counterexample #2 unexpectedly accepted"));
+    }
+
     #[test]
     fn test_validate_combined_required_and_one_of() {
         let tool = make_tool(
@@ -0,0 +1,157 @@
+use serde_json::Value;
+
+/// When a tool is listed and callable (`visibleWhen` in config).
+///
+/// A map from attribute path to condition; every condition must hold.
+/// Paths are dotted lookups into the session attributes:
+/// `protocolVersion` (the version the session negotiated) and
+/// `context.<field>` (the request context, e.g. `context.tenant_id`).
+///
+/// A condition is either a plain JSON value (equality) or an object with
+/// one operator: `in`, `notIn`, `contains` (array element or
+/// space-separated token, as in OAuth `scope` strings), `gte` or `lt`
+/// (string comparison, which orders protocol version dates).
+#[derive(Debug, Clone, PartialEq)]
+pub struct Visibility {
+    rules: Vec<(String, Condition)>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Condition {
+    Equals(Value),
+    In(Vec<Value>),
+    NotIn(Vec<Value>),
+    Contains(Value),
+    Gte(String),
+    Lt(String),
+}
+
+impl Visibility {
+    /// Parse a `visibleWhen` object.
+    pub fn parse(v: &Value) -> Result<Self, String> {
+        let map = v.as_object().ok_or("visibleWhen must be an object")?;
+        let rules = map
+            .iter()
+            .map(|(path, cond)| Ok((path.clone(), Condition::parse(path, cond)?)))
+            .collect::<Result<_, String>>()?;
+        Ok(Visibility { rules })
+    }
+
+    /// The `visibleWhen` object, as [`parse`](Self::parse) reads it.
+    pub fn to_value(&self) -> Value {
+        let rules = self.rules.iter().map(|(path, cond)| (path.clone(), cond.to_value()));
+        Value::Object(rules.collect())
+    }
+
+    /// Evaluate against `{"protocolVersion": ..., "context": {...}}`.
+    /// A missing attribute only satisfies `notIn`.
+    pub fn allows(&self, attrs: &Value) -> bool {
+        self.rules.iter().all(|(path, cond)| {
+            let value = path.split('.').try_fold(attrs, |v, seg| v.get(seg));
+            cond.holds(value)
+        })
+    }
+}
+
+impl Condition {
+    fn parse(path: &str, v: &Value) -> Result<Self, String> {
+        let Some(map) = v.as_object() else {
+            return Ok(Condition::Equals(v.clone()));
+        };
+        let mut ops = map.iter();
+        let (Some((op, arg)), None) = (ops.next(), ops.next()) else {
+            return Err(format!("visibleWhen.{}: expected exactly one operator", path));
+        };
+        let list = || {
+            arg.as_array()
+                .cloned()
+                .ok_or_else(|| format!("visibleWhen.{}.{}: expected an array", path, op))
+        };
+        let string = || {
+            arg.as_str()
+                .map(String::from)
+                .ok_or_else(|| format!("visibleWhen.{}.{}: expected a string", path, op))
+        };
+        match op.as_str() {
+            "in" => Ok(Condition::In(list()?)),
+            "notIn" => Ok(Condition::NotIn(list()?)),
+            "contains" => Ok(Condition::Contains(arg.clone())),
+            "gte" => Ok(Condition::Gte(string()?)),
+            "lt" => Ok(Condition::Lt(string()?)),
+            other => Err(format!("visibleWhen.{}: unknown operator {:?}", path, other)),
+        }
+    }
+
+    fn to_value(&self) -> Value {
+        let (op, arg) = match self {
+            Condition::Equals(v) => return v.clone(),
+            Condition::In(options) => ("in", Value::from(options.clone())),
+            Condition::NotIn(options) => ("notIn", Value::from(options.clone())),
+            Condition::Contains(needle) => ("contains", needle.clone()),
+            Condition::Gte(bound) => ("gte", Value::from(bound.as_str())),
+            Condition::Lt(bound) => ("lt", Value::from(bound.as_str())),
+        };
+        Value::Object([(op.to_string(), arg)].into_iter().collect())
+    }
+
+    fn holds(&self, value: Option<&Value>) -> bool {
+        let Some(value) = value else {
+            return matches!(self, Condition::NotIn(_));
+        };
+        match self {
+            Condition::Equals(expected) => value == expected,
+            Condition::In(options) => options.contains(value),
+            Condition::NotIn(options) => !options.contains(value),
+            Condition::Contains(needle) => match value {
+                Value::Array(items) => items.contains(needle),
+                Value::String(s) => needle
+                    .as_str()
+                    .is_some_and(|n| s.split_whitespace().any(|token| token == n)),
+                _ => false,
+            },
+            Condition::Gte(bound) => value.as_str().is_some_and(|s| s >= bound.as_str()),
+            Condition::Lt(bound) => value.as_str().is_some_and(|s| s < bound.as_str()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_conditions() {
+        let rule = Visibility::parse(&json!({
+            "protocolVersion": {"gte": "2025-03-26"},
+            "context.tenant_id": {"in": ["acme", "globex"]},
+            "context.scope": {"contains": "push:write"},
+        }))
+        .unwrap();
+        let attrs = |version: &str, tenant: &str, scope: &str| {
+            json!({"protocolVersion": version, "context": {"tenant_id": tenant, "scope": scope}})
+        };
+        assert!(rule.allows(&attrs("2025-06-18", "acme", "read push:write")));
+        assert!(!rule.allows(&attrs("2024-11-05", "acme", "push:write")));
+        assert!(!rule.allows(&attrs("2025-06-18", "initech", "push:write")));
+        assert!(!rule.allows(&attrs("2025-06-18", "acme", "push:writer")));
+        assert!(!rule.allows(&json!({"context": {}})));
+        assert_eq!(Visibility::parse(&rule.to_value()).unwrap(), rule);
+    }
+
+    #[test]
+    fn test_equality_and_missing_attributes() {
+        let rule = Visibility::parse(&json!({"context.beta": true, "context.region": {"notIn": ["cn"]}})).unwrap();
+        assert!(rule.allows(&json!({"context": {"beta": true}})));
+        assert!(!rule.allows(&json!({"context": {"beta": true, "region": "cn"}})));
+        assert!(!rule.allows(&json!({"context": {"beta": "true"}})));
+    }
+
+    #[test]
+    fn test_parse_errors() {
+        assert!(Visibility::parse(&json!([])).is_err());
+        assert!(Visibility::parse(&json!({"a": {"in": "x"}})).is_err());
+        assert!(Visibility::parse(&json!({"a": {"matches": "x"}})).is_err());
+        assert!(Visibility::parse(&json!({"a": {"gte": "1", "lt": "2"}})).is_err());
+    }
+}